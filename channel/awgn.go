@@ -0,0 +1,67 @@
+package channel
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Awgn adds complex Gaussian noise to samples. The noise is drawn from its own
+// seeded source, so a simulation is reproducible.
+type Awgn struct {
+	random *rand.Rand
+	sigma  float64 // standard deviation of I or Q
+}
+
+func NewAwgn(seed int64) *Awgn {
+	var a Awgn
+
+	a.random = rand.New(rand.NewSource(seed))
+
+	return &a
+}
+
+// SetEsN0 sets the noise for Es/N0 in dB. The symbol energy is the mean power
+// of samples times samples per symbol, so the noise of the whole sample band
+// is counted.
+func (a *Awgn) SetEsN0(esN0 float64, signalPower float64, samplesPerSymbol float64) {
+	n0 := signalPower * samplesPerSymbol / math.Pow(10.0, esN0/10.0)
+	a.sigma = math.Sqrt(n0 / 2.0)
+}
+
+// SetEbN0 sets the noise for Eb/N0 in dB, efficiency is the number of
+// information bits per transmitted symbol, PLHEADER and pilots included.
+func (a *Awgn) SetEbN0(ebN0 float64, signalPower float64, samplesPerSymbol float64, efficiency float64) {
+	a.SetEsN0(EbN0ToEsN0(ebN0, efficiency), signalPower, samplesPerSymbol)
+}
+
+// NoiseVariance returns the power of the complex noise per sample.
+func (a *Awgn) NoiseVariance() float64 {
+	return 2.0 * a.sigma * a.sigma
+}
+
+// Process adds noise to samples in place.
+func (a *Awgn) Process(samples []complex128) []complex128 {
+	for i, value := range samples {
+		samples[i] = value + complex(a.sigma*a.random.NormFloat64(), a.sigma*a.random.NormFloat64())
+	}
+	return samples
+}
+
+// EbN0ToEsN0 converts Eb/N0 in dB to Es/N0 in dB.
+func EbN0ToEsN0(ebN0 float64, efficiency float64) float64 {
+	return ebN0 + 10.0*math.Log10(efficiency)
+}
+
+// MeanPower returns the mean power of samples.
+func MeanPower(samples []complex128) float64 {
+	if len(samples) == 0 {
+		return 0.0
+	}
+
+	power := 0.0
+	for _, value := range samples {
+		power += real(value)*real(value) + imag(value)*imag(value)
+	}
+
+	return power / float64(len(samples))
+}
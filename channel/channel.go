@@ -0,0 +1,20 @@
+// Package channel simulates the transmission channel of baseband samples of
+// the encoder. Blocks keep their state from call to call, so a stream may be
+// passed in pieces of any size.
+package channel
+
+// Block is a stage of the channel. Process works in place where the number of
+// samples is kept, the returned samples are valid until the next call.
+type Block interface {
+	Process(samples []complex128) []complex128
+}
+
+// Chain passes samples through blocks in order.
+type Chain []Block
+
+func (c Chain) Process(samples []complex128) []complex128 {
+	for _, block := range c {
+		samples = block.Process(samples)
+	}
+	return samples
+}
@@ -0,0 +1,275 @@
+package channel
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func TestAwgn(t *testing.T) {
+	t.Run("EsN0", func(t *testing.T) {
+		a := NewAwgn(1)
+		a.SetEsN0(10.0, 0.5, 2.0)
+		if math.Abs(a.NoiseVariance()-0.1) > 1e-12 {
+			t.Errorf("noise variance %f != 0.1\n", a.NoiseVariance())
+		}
+
+		samples := make([]complex128, 100000)
+		a.Process(samples)
+		if power := MeanPower(samples); math.Abs(power-0.1) > 0.002 {
+			t.Errorf("noise power %f != 0.1\n", power)
+		}
+		re, im := 0.0, 0.0
+		for _, value := range samples {
+			re += real(value) * real(value)
+			im += imag(value) * imag(value)
+		}
+		if math.Abs(re-im)/(re+im) > 0.02 {
+			t.Errorf("noise is not circular: %f %f\n", re, im)
+		}
+	})
+
+	t.Run("EbN0", func(t *testing.T) {
+		if esN0 := EbN0ToEsN0(3.0, 2.0); math.Abs(esN0-3.0-10.0*math.Log10(2.0)) > 1e-12 {
+			t.Errorf("Es/N0 %f\n", esN0)
+		}
+
+		a := NewAwgn(1)
+		b := NewAwgn(1)
+		a.SetEbN0(3.0, 1.0, 4.0, 1.487473)
+		b.SetEsN0(EbN0ToEsN0(3.0, 1.487473), 1.0, 4.0)
+		if a.NoiseVariance() != b.NoiseVariance() {
+			t.Errorf("%f != %f\n", a.NoiseVariance(), b.NoiseVariance())
+		}
+	})
+
+	t.Run("seed", func(t *testing.T) {
+		samples := [3][]complex128{make([]complex128, 16), make([]complex128, 16), make([]complex128, 16)}
+		for i, seed := range []int64{7, 7, 8} {
+			a := NewAwgn(seed)
+			a.SetEsN0(0.0, 1.0, 1.0)
+			a.Process(samples[i])
+		}
+		for i := range samples[0] {
+			if samples[0][i] != samples[1][i] {
+				t.Fatal("same seed gives different noise")
+			}
+		}
+		if samples[0][0] == samples[2][0] {
+			t.Error("different seeds give the same noise")
+		}
+	})
+}
+
+func TestImpairments(t *testing.T) {
+	ones := func(n int) []complex128 {
+		samples := make([]complex128, n)
+		for i := range samples {
+			samples[i] = 1.0
+		}
+		return samples
+	}
+
+	t.Run("FrequencyOffset", func(t *testing.T) {
+		f := NewFrequencyOffset(0.01, 1e-6)
+		samples := ones(1000)
+		f.Process(samples[:300])
+		f.Process(samples[300:])
+		for n, value := range samples {
+			phase := 2.0 * math.Pi * (0.01*float64(n) + 1e-6*float64(n*(n-1))/2.0)
+			if cmplx.Abs(value-cmplx.Rect(1.0, phase)) > 1e-9 {
+				t.Fatalf("sample %d: %v\n", n, value)
+			}
+		}
+	})
+
+	t.Run("WienerPhaseNoise", func(t *testing.T) {
+		w := NewWienerPhaseNoise(1e-4, 1)
+		samples := w.Process(ones(100000))
+		variance := 0.0
+		for i := 1; i < len(samples); i++ {
+			step := cmplx.Phase(samples[i] * cmplx.Conj(samples[i-1]))
+			variance += step * step
+		}
+		variance /= float64(len(samples) - 1)
+		if math.Abs(variance/(2.0*math.Pi*1e-4)-1.0) > 0.05 {
+			t.Errorf("phase step variance %g\n", variance)
+		}
+	})
+
+	t.Run("MaskPhaseNoise", func(t *testing.T) {
+		mask := []PhaseNoiseMaskPoint{{1e3, -70.0}, {1e4, -80.0}, {1e5, -100.0}}
+		if level := maskLevel(mask, math.Sqrt(1e3*1e4)); math.Abs(level+75.0) > 1e-9 {
+			t.Errorf("level %f != -75\n", level)
+		}
+		if maskLevel(mask, 10.0) != -70.0 || maskLevel(mask, 1e6) != -100.0 {
+			t.Error("levels beyond the mask")
+		}
+
+		flat := []PhaseNoiseMaskPoint{{1.0, -80.0}}
+		p := NewMaskPhaseNoise(flat, 1e6, 1)
+		if math.Abs(p.variance()/0.01-1.0) > 1e-6 {
+			t.Errorf("filter variance %g != 0.01\n", p.variance())
+		}
+
+		samples := p.Process(ones(1 << 18))
+		variance := 0.0
+		for _, value := range samples {
+			variance += cmplx.Phase(value) * cmplx.Phase(value)
+		}
+		variance /= float64(len(samples))
+		if math.Abs(variance/0.01-1.0) > 0.05 {
+			t.Errorf("phase variance %g != 0.01\n", variance)
+		}
+	})
+
+	t.Run("IqImbalance", func(t *testing.T) {
+		g := math.Pow(10.0, 1.0/20.0)
+		phi := 0.1
+		q := NewIqImbalance(1.0, phi)
+		samples := q.Process([]complex128{1.0, 1.0i})
+		if cmplx.Abs(samples[0]-complex(1.0, -g*math.Sin(phi))) > 1e-12 || cmplx.Abs(samples[1]-complex(0.0, g*math.Cos(phi))) > 1e-12 {
+			t.Errorf("%v\n", samples)
+		}
+
+		samples = Chain{NewIqImbalance(0.0, 0.0), NewDcOffset(0.5 - 0.25i)}.Process([]complex128{1.0 + 2.0i})
+		if cmplx.Abs(samples[0]-(1.5+1.75i)) > 1e-12 {
+			t.Errorf("%v\n", samples)
+		}
+	})
+
+	t.Run("ClockOffset", func(t *testing.T) {
+		tone := make([]complex128, 20000)
+		for i := range tone {
+			tone[i] = cmplx.Rect(1.0, 2.0*math.Pi*0.01*float64(i))
+		}
+
+		c := NewClockOffset(0.0)
+		output := append([]complex128(nil), c.Process(tone[:777])...)
+		output = append(output, c.Process(tone[777:])...)
+		if len(output) != len(tone)-2 {
+			t.Fatalf("%d samples\n", len(output))
+		}
+		for i, value := range output {
+			if cmplx.Abs(value-tone[i]) > 1e-12 {
+				t.Fatalf("sample %d: %v != %v\n", i, value, tone[i])
+			}
+		}
+
+		c = NewClockOffset(1000.0)
+		output = output[:0]
+		for i := 0; i < len(tone); i += 1000 {
+			output = append(output, c.Process(tone[i:i+1000])...)
+		}
+		if n := float64(len(tone)) / 1.001; math.Abs(float64(len(output))-n) > 3.0 {
+			t.Errorf("%d samples != %f\n", len(output), n)
+		}
+		for i, value := range output {
+			expected := cmplx.Rect(1.0, 2.0*math.Pi*0.01*float64(i)*1.001)
+			if cmplx.Abs(value-expected) > 1e-5 {
+				t.Fatalf("sample %d: %v != %v\n", i, value, expected)
+			}
+		}
+	})
+}
+
+func TestHpa(t *testing.T) {
+	t.Run("Saleh", func(t *testing.T) {
+		s := NewSalehTwta()
+		amplitude, phase := s.Response(s.Saturation())
+		if math.Abs(amplitude-2.1587/(2.0*math.Sqrt(1.1517))) > 1e-12 {
+			t.Errorf("saturation amplitude %f\n", amplitude)
+		}
+		if math.Abs(phase-4.0033/1.1517/(1.0+9.1040/1.1517)) > 1e-12 {
+			t.Errorf("saturation phase %f\n", phase)
+		}
+		for _, r := range []float64{0.5, 1.5} {
+			if a, _ := s.Response(r); a >= amplitude {
+				t.Errorf("amplitude %f at %f over the saturation\n", a, r)
+			}
+		}
+	})
+
+	t.Run("Rapp", func(t *testing.T) {
+		r := NewRapp(2.0, 1.0, 3.0)
+		if r.Saturation() != 0.5 {
+			t.Errorf("saturation %f\n", r.Saturation())
+		}
+		if a, p := r.Response(0.01); math.Abs(a-0.02) > 1e-9 || p != 0.0 {
+			t.Errorf("small signal %f %f\n", a, p)
+		}
+		if a, _ := r.Response(100.0); math.Abs(a-1.0) > 1e-6 {
+			t.Errorf("large signal %f\n", a)
+		}
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		h := NewHpa(NewRapp(1.0, 1.0, 2.0))
+		h.SetInputBackoff(30.0, 4.0)
+		samples := h.Process([]complex128{2.0i, -1.0 + 1.0i})
+		if cmplx.Abs(samples[0]-2.0i) > 1e-5 || cmplx.Abs(samples[1]-(-1.0+1.0i)) > 1e-5 {
+			t.Errorf("%v\n", samples)
+		}
+
+		h = NewHpa(NewSalehTwta())
+		h.SetInputBackoff(0.0, 1.0)
+		samples = h.Process([]complex128{1.0})
+		amplitude, phase := NewSalehTwta().Response(NewSalehTwta().Saturation())
+		expected := cmplx.Rect(amplitude*math.Sqrt(1.1517), phase)
+		if cmplx.Abs(samples[0]-expected) > 1e-12 {
+			t.Errorf("%v != %v\n", samples[0], expected)
+		}
+	})
+}
+
+func TestSpectrum(t *testing.T) {
+	t.Run("Noise", func(t *testing.T) {
+		samples := make([]complex128, 1<<16)
+		a := NewAwgn(3)
+		a.SetEsN0(0.0, 2.0, 1.0)
+		a.Process(samples)
+
+		w := NewWelch(256)
+		if out := w.Process(samples); &out[0] != &samples[0] {
+			t.Error("samples are not passed on")
+		}
+		s := w.Spectrum()
+		if power := s.Power(-0.5, 0.5); math.Abs(power-MeanPower(samples)) > 0.02 {
+			t.Errorf("power %f, mean power %f\n", power, MeanPower(samples))
+		}
+		for i, value := range s.Density {
+			if math.Abs(value-2.0) > 0.3 {
+				t.Fatalf("density %f at %f\n", value, s.Frequency(i))
+			}
+		}
+		if bandwidth := s.OccupiedBandwidth(0.99); math.Abs(bandwidth-0.99) > 0.01 {
+			t.Errorf("occupied bandwidth %f\n", bandwidth)
+		}
+	})
+
+	t.Run("Tone", func(t *testing.T) {
+		samples := make([]complex128, 10000)
+		for i := range samples {
+			samples[i] = 0.1
+		}
+		NewFrequencyOffset(0.25, 0.0).Process(samples)
+		for i := range samples {
+			samples[i] += 1.0
+		}
+
+		w := NewWelch(64)
+		w.Process(samples[:5000])
+		w.Process(samples[5000:])
+		s := w.Spectrum()
+		if s.Frequency(48) != 0.25 || s.Density[48] < 1e3*s.Density[40] {
+			t.Errorf("tone %f, next to the tone %f\n", s.Density[48], s.Density[40])
+		}
+		if power := s.Power(0.2, 0.3); math.Abs(power-0.01) > 1e-5 {
+			t.Errorf("tone power %f\n", power)
+		}
+		lower, upper := s.Acpr(0.1, 0.25)
+		if math.Abs(upper+20.0) > 0.01 || lower > -60.0 {
+			t.Errorf("ACPR %f %f dB\n", lower, upper)
+		}
+	})
+}
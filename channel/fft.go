@@ -0,0 +1,48 @@
+package channel
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fft is the in place radix-2 transform, len(x) is a power of 2. The inverse
+// transform is scaled by 1/len(x).
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit > 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Rect(1.0, sign*2.0*math.Pi/float64(size))
+		for i := 0; i < n; i += size {
+			t := complex(1.0, 0.0)
+			for j := i; j < i+size/2; j++ {
+				a := x[j]
+				b := x[j+size/2] * t
+				x[j] = a + b
+				x[j+size/2] = a - b
+				t *= w
+			}
+		}
+	}
+
+	if inverse {
+		scale := complex(1.0/float64(n), 0.0)
+		for i := range x {
+			x[i] *= scale
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package channel
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Amplifier is the memoryless model of a high power amplifier, AM/AM and
+// AM/PM responses to the input amplitude.
+type Amplifier interface {
+	Response(amplitude float64) (float64, float64)
+	Saturation() float64 // input amplitude of the output saturation
+}
+
+// Saleh is the TWTA model of A. Saleh, A(r) = aa*r/(1+ba*r^2) and
+// P(r) = ap*r^2/(1+bp*r^2).
+type Saleh struct {
+	alphaA float64
+	betaA  float64
+	alphaP float64
+	betaP  float64
+}
+
+func NewSaleh(alphaA float64, betaA float64, alphaP float64, betaP float64) *Saleh {
+	return &Saleh{alphaA: alphaA, betaA: betaA, alphaP: alphaP, betaP: betaP}
+}
+
+// NewSalehTwta returns the model fitted by Saleh to a measured TWTA.
+func NewSalehTwta() *Saleh {
+	return NewSaleh(2.1587, 1.1517, 4.0033, 9.1040)
+}
+
+func (s *Saleh) Response(amplitude float64) (float64, float64) {
+	r2 := amplitude * amplitude
+	return s.alphaA * amplitude / (1.0 + s.betaA*r2), s.alphaP * r2 / (1.0 + s.betaP*r2)
+}
+
+func (s *Saleh) Saturation() float64 {
+	return 1.0 / math.Sqrt(s.betaA)
+}
+
+// Rapp is the SSPA model of C. Rapp without AM/PM, the output amplitude goes
+// smoothly from gain*r to the saturation level.
+type Rapp struct {
+	gain       float64
+	saturation float64 // output amplitude
+	smoothness float64
+}
+
+func NewRapp(gain float64, saturation float64, smoothness float64) *Rapp {
+	return &Rapp{gain: gain, saturation: saturation, smoothness: smoothness}
+}
+
+func (r *Rapp) Response(amplitude float64) (float64, float64) {
+	x := r.gain * amplitude / r.saturation
+	return r.gain * amplitude / math.Pow(1.0+math.Pow(x, 2.0*r.smoothness), 1.0/(2.0*r.smoothness)), 0.0
+}
+
+// Saturation of Rapp is the input amplitude of the linear output reaching the
+// saturation level.
+func (r *Rapp) Saturation() float64 {
+	return r.saturation / r.gain
+}
+
+// Hpa drives the amplifier with the input backoff, the mean input power below
+// the saturation input power. The output is scaled back by the same gain.
+type Hpa struct {
+	amplifier Amplifier
+	gain      float64
+}
+
+func NewHpa(amplifier Amplifier) *Hpa {
+	return &Hpa{amplifier: amplifier, gain: 1.0}
+}
+
+// SetInputBackoff sets the backoff in dB for samples of the mean power.
+func (h *Hpa) SetInputBackoff(backoff float64, signalPower float64) {
+	saturation := h.amplifier.Saturation()
+	h.gain = saturation * math.Pow(10.0, -backoff/20.0) / math.Sqrt(signalPower)
+}
+
+func (h *Hpa) Process(samples []complex128) []complex128 {
+	for i, value := range samples {
+		amplitude, phase := h.amplifier.Response(cmplx.Abs(value) * h.gain)
+		samples[i] = cmplx.Rect(amplitude/h.gain, cmplx.Phase(value)+phase)
+	}
+	return samples
+}
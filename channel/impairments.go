@@ -0,0 +1,160 @@
+package channel
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+)
+
+// FrequencyOffset turns samples by the carrier offset growing with the drift,
+// frequencies are in cycles per sample.
+type FrequencyOffset struct {
+	frequency float64
+	drift     float64 // change of frequency per sample
+	phase     float64 // cycles
+}
+
+func NewFrequencyOffset(frequency float64, drift float64) *FrequencyOffset {
+	var f FrequencyOffset
+
+	f.frequency = frequency
+	f.drift = drift
+
+	return &f
+}
+
+func (f *FrequencyOffset) Process(samples []complex128) []complex128 {
+	for i, value := range samples {
+		samples[i] = value * cmplx.Rect(1.0, 2.0*math.Pi*f.phase)
+		f.phase += f.frequency
+		f.phase -= math.Floor(f.phase)
+		f.frequency += f.drift
+	}
+	return samples
+}
+
+// WienerPhaseNoise is the random walk of phase of an oscillator with the
+// Lorentzian spectrum of the 3 dB linewidth, in cycles per sample.
+type WienerPhaseNoise struct {
+	random *rand.Rand
+	sigma  float64 // phase step, radians
+	phase  float64
+}
+
+func NewWienerPhaseNoise(linewidth float64, seed int64) *WienerPhaseNoise {
+	var w WienerPhaseNoise
+
+	w.random = rand.New(rand.NewSource(seed))
+	w.sigma = math.Sqrt(2.0 * math.Pi * linewidth)
+
+	return &w
+}
+
+func (w *WienerPhaseNoise) Process(samples []complex128) []complex128 {
+	for i, value := range samples {
+		samples[i] = value * cmplx.Rect(1.0, w.phase)
+		w.phase = math.Remainder(w.phase+w.sigma*w.random.NormFloat64(), 2.0*math.Pi)
+	}
+	return samples
+}
+
+// IqImbalance scales Q by the gain in dB and turns it by the phase in radians
+// against I, y = mu*x + nu*conj(x).
+type IqImbalance struct {
+	mu complex128
+	nu complex128
+}
+
+func NewIqImbalance(gain float64, phase float64) *IqImbalance {
+	var q IqImbalance
+
+	g := math.Pow(10.0, gain/20.0)
+	q.mu = (1.0 + cmplx.Rect(g, -phase)) / 2.0
+	q.nu = (1.0 - cmplx.Rect(g, phase)) / 2.0
+
+	return &q
+}
+
+func (q *IqImbalance) Process(samples []complex128) []complex128 {
+	for i, value := range samples {
+		samples[i] = q.mu*value + q.nu*cmplx.Conj(value)
+	}
+	return samples
+}
+
+// DcOffset adds a constant to samples.
+type DcOffset struct {
+	offset complex128
+}
+
+func NewDcOffset(offset complex128) *DcOffset {
+	return &DcOffset{offset: offset}
+}
+
+func (d *DcOffset) Process(samples []complex128) []complex128 {
+	for i, value := range samples {
+		samples[i] = value + d.offset
+	}
+	return samples
+}
+
+// Resampler takes samples of the stream every step input samples, they are
+// interpolated by the cubic Lagrange polynomial over four inputs.
+type Resampler struct {
+	step     float64
+	position float64 // of the next output, from history[0]
+	history  [3]complex128
+	buffer   []complex128
+	output   []complex128
+}
+
+func NewResampler(step float64) *Resampler {
+	var c Resampler
+
+	c.step = step
+	c.position = float64(len(c.history))
+
+	return &c
+}
+
+// NewClockOffset resamples the stream as seen by a clock off by ppm, a
+// positive offset makes fewer samples.
+func NewClockOffset(ppm float64) *Resampler {
+	return NewResampler(1.0 + ppm*1e-6)
+}
+
+func (c *Resampler) Process(samples []complex128) []complex128 {
+	n := len(c.history) + len(samples)
+	if cap(c.buffer) < n {
+		c.buffer = make([]complex128, n)
+		c.output = make([]complex128, 0, int(float64(len(samples))/c.step)+2)
+	}
+	buffer := c.buffer[:n]
+	copy(buffer, c.history[:])
+	copy(buffer[len(c.history):], samples)
+
+	output := c.output[:0]
+	for c.position+2.0 < float64(n) {
+		i := int(c.position)
+		mu := c.position - float64(i)
+		output = append(output, lagrange3(buffer[i-1:i+3], mu))
+		c.position += c.step
+	}
+
+	consumed := n - len(c.history)
+	copy(c.history[:], buffer[consumed:])
+	c.position -= float64(consumed)
+	c.output = output
+
+	return output
+}
+
+// lagrange3 interpolates x at 1+mu, 0 <= mu < 1.
+func lagrange3(x []complex128, mu float64) complex128 {
+	c0 := -mu * (mu - 1.0) * (mu - 2.0) / 6.0
+	c1 := (mu + 1.0) * (mu - 1.0) * (mu - 2.0) / 2.0
+	c2 := -(mu + 1.0) * mu * (mu - 2.0) / 2.0
+	c3 := (mu + 1.0) * mu * (mu - 1.0) / 6.0
+
+	return complex(c0, 0)*x[0] + complex(c1, 0)*x[1] + complex(c2, 0)*x[2] + complex(c3, 0)*x[3]
+}
@@ -0,0 +1,120 @@
+package channel
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+)
+
+const maskPhaseNoiseTaps int = 4096
+
+// PhaseNoiseMaskPoint is a point of the single sideband phase noise L(f).
+type PhaseNoiseMaskPoint struct {
+	Offset float64 // Hz
+	Level  float64 // dBc/Hz
+}
+
+// MaskPhaseNoise turns samples by the phase of white noise shaped to the mask.
+// The shaping filter is designed by frequency sampling of the mask and runs by
+// overlap-save, levels are interpolated linearly in dB over log frequency.
+type MaskPhaseNoise struct {
+	random   *rand.Rand
+	response []complex128
+	block    []complex128
+	noise    []float64
+	phase    []float64
+	pointer  int
+}
+
+func NewMaskPhaseNoise(mask []PhaseNoiseMaskPoint, sampleRate float64, seed int64) *MaskPhaseNoise {
+	var p MaskPhaseNoise
+
+	taps := maskPhaseNoiseTaps
+	p.random = rand.New(rand.NewSource(seed))
+
+	h := make([]complex128, taps)
+	for k := range h {
+		f := float64(k) * sampleRate / float64(taps)
+		if k > taps/2 {
+			f = sampleRate - f
+		}
+		h[k] = complex(math.Sqrt(math.Pow(10.0, maskLevel(mask, f)/10.0)*sampleRate), 0.0)
+	}
+	fft(h, true)
+
+	p.response = make([]complex128, 2*taps)
+	for i := range h {
+		p.response[i] = h[(i+taps/2)%taps]
+	}
+	fft(p.response, false)
+
+	p.block = make([]complex128, 2*taps)
+	p.noise = make([]float64, taps)
+	for i := range p.noise {
+		p.noise[i] = p.random.NormFloat64()
+	}
+	p.phase = make([]float64, taps)
+	p.pointer = taps
+
+	return &p
+}
+
+// maskLevel returns L(f) of the mask, levels hold beyond the ends.
+func maskLevel(mask []PhaseNoiseMaskPoint, f float64) float64 {
+	if f <= mask[0].Offset {
+		return mask[0].Level
+	}
+	for i := 1; i < len(mask); i++ {
+		if f <= mask[i].Offset {
+			a := mask[i-1]
+			b := mask[i]
+			return a.Level + (b.Level-a.Level)*math.Log(f/a.Offset)/math.Log(b.Offset/a.Offset)
+		}
+	}
+	return mask[len(mask)-1].Level
+}
+
+// variance returns the power of the phase, radians squared.
+func (p *MaskPhaseNoise) variance() float64 {
+	h := make([]complex128, len(p.response))
+	copy(h, p.response)
+	fft(h, true)
+
+	variance := 0.0
+	for _, value := range h {
+		variance += real(value) * real(value)
+	}
+	return variance
+}
+
+func (p *MaskPhaseNoise) Process(samples []complex128) []complex128 {
+	for i, value := range samples {
+		if p.pointer == len(p.phase) {
+			p.generate()
+		}
+		samples[i] = value * cmplx.Rect(1.0, p.phase[p.pointer])
+		p.pointer++
+	}
+	return samples
+}
+
+func (p *MaskPhaseNoise) generate() {
+	taps := len(p.noise)
+
+	for i, value := range p.noise {
+		p.block[i] = complex(value, 0.0)
+		p.noise[i] = p.random.NormFloat64()
+		p.block[i+taps] = complex(p.noise[i], 0.0)
+	}
+
+	fft(p.block, false)
+	for i := range p.block {
+		p.block[i] *= p.response[i]
+	}
+	fft(p.block, true)
+
+	for i := range p.phase {
+		p.phase[i] = real(p.block[i+taps])
+	}
+	p.pointer = 0
+}
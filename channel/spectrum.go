@@ -0,0 +1,140 @@
+package channel
+
+import (
+	"math"
+)
+
+// Welch estimates the power spectral density of the samples passing through
+// by the Welch method, segments of the Hann window overlap by half. Samples are
+// passed on unchanged, so Welch may watch any point of a chain.
+type Welch struct {
+	window   []float64
+	segment  []complex128
+	block    []complex128
+	fill     int
+	sum      []float64
+	segments int
+}
+
+// NewWelch makes the estimator of segments of size samples, a power of 2.
+func NewWelch(size int) *Welch {
+	var w Welch
+
+	if size < 2 || size&(size-1) != 0 {
+		panic("size of Welch segment is not a power of 2\n")
+	}
+
+	w.window = make([]float64, size)
+	for i := range w.window {
+		w.window[i] = 0.5 - 0.5*math.Cos(2.0*math.Pi*float64(i)/float64(size))
+	}
+	w.segment = make([]complex128, size)
+	w.block = make([]complex128, size)
+	w.sum = make([]float64, size)
+
+	return &w
+}
+
+func (w *Welch) Process(samples []complex128) []complex128 {
+	size := len(w.segment)
+
+	for _, value := range samples {
+		w.segment[w.fill] = value
+		w.fill++
+		if w.fill < size {
+			continue
+		}
+
+		for i, value := range w.segment {
+			w.block[i] = value * complex(w.window[i], 0.0)
+		}
+		fft(w.block, false)
+		for i, value := range w.block {
+			w.sum[i] += real(value)*real(value) + imag(value)*imag(value)
+		}
+		w.segments++
+
+		copy(w.segment, w.segment[size/2:])
+		w.fill = size / 2
+	}
+
+	return samples
+}
+
+// Spectrum returns the mean of segments so far.
+func (w *Welch) Spectrum() *Spectrum {
+	size := len(w.sum)
+	s := Spectrum{Density: make([]float64, size)}
+
+	if w.segments == 0 {
+		return &s
+	}
+
+	energy := 0.0
+	for _, value := range w.window {
+		energy += value * value
+	}
+	scale := 1.0 / (energy * float64(w.segments))
+	for i, value := range w.sum {
+		s.Density[(i+size/2)%size] = value * scale
+	}
+
+	return &s
+}
+
+// Spectrum is the power spectral density in bins from -1/2 to 1/2 cycles per
+// sample, the bin size/2 is the zero frequency. The density is the power per
+// cycle per sample, so the mean of bins is the mean power of samples.
+type Spectrum struct {
+	Density []float64
+}
+
+// Frequency returns the center of the bin i in cycles per sample.
+func (s *Spectrum) Frequency(i int) float64 {
+	return float64(i-len(s.Density)/2) / float64(len(s.Density))
+}
+
+// Power returns the power of bins with centers from low to high cycles per
+// sample.
+func (s *Spectrum) Power(low float64, high float64) float64 {
+	power := 0.0
+	for i, value := range s.Density {
+		if f := s.Frequency(i); f >= low && f <= high {
+			power += value
+		}
+	}
+	return power / float64(len(s.Density))
+}
+
+// OccupiedBandwidth returns the width in cycles per sample holding the
+// fraction of the power, the rest is split evenly between the tails.
+func (s *Spectrum) OccupiedBandwidth(fraction float64) float64 {
+	total := 0.0
+	for _, value := range s.Density {
+		total += value
+	}
+	tail := total * (1.0 - fraction) / 2.0
+
+	low := 0
+	for power := s.Density[0]; power < tail && low < len(s.Density)-1; power += s.Density[low] {
+		low++
+	}
+	high := len(s.Density) - 1
+	for power := s.Density[high]; power < tail && high > 0; power += s.Density[high] {
+		high--
+	}
+
+	return float64(high-low+1) / float64(len(s.Density))
+}
+
+// Acpr returns the ratios in dB of the power of the lower and the upper
+// adjacent channels to the power of the channel at the zero frequency.
+// Channels are bandwidth wide at the spacing, in cycles per sample, parts of
+// adjacent channels beyond 1/2 are left out.
+func (s *Spectrum) Acpr(bandwidth float64, spacing float64) (float64, float64) {
+	channel := s.Power(-bandwidth/2.0, bandwidth/2.0)
+	lower := s.Power(-spacing-bandwidth/2.0, -spacing+bandwidth/2.0)
+	upper := s.Power(spacing-bandwidth/2.0, spacing+bandwidth/2.0)
+
+	return 10.0 * math.Log10(lower/channel), 10.0 * math.Log10(upper/channel)
+}
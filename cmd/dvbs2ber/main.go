@@ -0,0 +1,79 @@
+// Command dvbs2ber sweeps Es/N0 of the AWGN channel for a MODCOD and prints
+// BER, FER and packet error rate of every point as CSV.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	dvb2s "github.com/ivantaran/dvb2sgo"
+)
+
+func main() {
+	modcodHelp, frameHelp := dvb2s.ModcodUsage()
+	modcod := flag.Int("modcod", 7, modcodHelp)
+	frame := flag.String("frame", "normal", frameHelp)
+	pilots := flag.Bool("pilots", false, "insert pilots")
+	start := flag.Float64("start", 3.0, "first Es/N0, dB")
+	stop := flag.Float64("stop", 5.0, "last Es/N0, dB")
+	step := flag.Float64("step", 0.1, "Es/N0 step, dB")
+	frameErrors := flag.Int("errors", 100, "frame errors to stop a point")
+	frames := flag.Int("frames", 10000, "frames to stop a point")
+	seed := flag.Int64("seed", 1, "seed of data and noise")
+	iterations := flag.Int("iterations", 50, "maximum LDPC decoder iterations")
+	scale := flag.Float64("scale", 0.75, "scale of LDPC min-sum messages, (0, 1]")
+	offset := flag.Float64("offset", 0.0, "offset of LDPC min-sum messages, 0 for normalized min-sum")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("dvbs2ber: ")
+
+	if *step <= 0.0 {
+		log.Fatal("step must be positive")
+	}
+
+	s, err := dvb2s.NewLinkSimulation(*modcod, *frame, *pilots, *seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := s.SetLdpc(*iterations, *scale, *offset); err != nil {
+		log.Fatal(err)
+	}
+
+	writer := csv.NewWriter(os.Stdout)
+	writer.Write([]string{
+		"esn0", "ebn0", "frames", "frame_errors", "fer",
+		"bits", "bit_errors", "ber", "packets", "packet_errors", "per",
+	})
+
+	format := func(value float64) string {
+		return strconv.FormatFloat(value, 'g', 6, 64)
+	}
+
+	for i := 0; ; i++ {
+		esN0 := *start + float64(i)*(*step)
+		if esN0 > *stop+*step/2.0 {
+			break
+		}
+
+		l, err := s.Run(esN0, *frameErrors, *frames)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		writer.Write([]string{
+			format(l.EsN0), format(l.EbN0),
+			fmt.Sprint(l.Frames), fmt.Sprint(l.FrameErrors), format(l.Fer()),
+			fmt.Sprint(l.Bits), fmt.Sprint(l.BitErrors), format(l.Ber()),
+			fmt.Sprint(l.Packets), fmt.Sprint(l.PacketErrors), format(l.Per()),
+		})
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
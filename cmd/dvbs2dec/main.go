@@ -0,0 +1,169 @@
+// Command dvbs2dec recovers the transport stream of a DVB-S2 baseband IQ
+// recording, such as written by dvbs2enc. Samples are read from a file or
+// stdin, TS packets go to a file or stdout. Lock state, MODCOD, MER and error
+// counts are printed to stderr when they change and every status period of
+// the recording.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+
+	dvb2s "github.com/ivantaran/dvb2sgo"
+	"github.com/ivantaran/dvb2sgo/channel"
+)
+
+const readSize int = 1 << 16 // samples
+
+func main() {
+	input := flag.String("input", "-", "IQ file, - for stdin")
+	output := flag.String("output", "-", "transport stream file, - for stdout")
+	format := flag.String("format", "cs16le", "sample format: cs8, cs16le, cs16be, cf32 or cf64")
+	rolloff := flag.Float64("rolloff", 0.35, "roll-off: 0.35, 0.25 or 0.20")
+	symbolRate := flag.Float64("symbolrate", 1e6, "symbol rate, Hz")
+	sampleRate := flag.Float64("samplerate", 2e6, "sample rate, Hz, at least twice the symbol rate")
+	gold := flag.Int("gold", 0, "gold code of PL scrambling")
+	period := flag.Float64("status", 1.0, "status period of the recording, s, 0 for changes only")
+	iterations := flag.Int("iterations", 50, "maximum LDPC decoder iterations")
+	scale := flag.Float64("scale", 0.75, "scale of LDPC min-sum messages, (0, 1]")
+	offset := flag.Float64("offset", 0.0, "offset of LDPC min-sum messages, 0 for normalized min-sum")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("dvbs2dec: ")
+
+	ratio := *sampleRate / *symbolRate
+	if !(ratio >= 2.0) || math.IsInf(ratio, 0) {
+		log.Fatal("sample rate must be at least twice the symbol rate")
+	}
+	oversampling := int(math.Round(ratio))
+	var resampler *channel.Resampler
+	if math.Abs(ratio-float64(oversampling)) > 1e-9*ratio {
+		oversampling = int(math.Max(4.0, math.Ceil(ratio)))
+		resampler = channel.NewResampler(ratio / float64(oversampling))
+	}
+
+	var in io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	reader, err := dvb2s.NewSampleReader(in, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var out io.WriteCloser = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out = file
+	}
+	writer := bufio.NewWriter(out)
+
+	d, err := dvb2s.NewDecoder(writer, oversampling)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := d.SetRolloff(*rolloff); err != nil {
+		log.Fatal(err)
+	}
+	if err := d.SetGoldCode(*gold); err != nil {
+		log.Fatal(err)
+	}
+	if err := d.SetLdpc(*iterations, *scale, *offset); err != nil {
+		log.Fatal(err)
+	}
+
+	samples := make([]complex128, readSize)
+	read := 0
+	next := *period * *sampleRate
+	last := d.Status()
+	printStatus(0.0, last)
+
+	for {
+		n, err := reader.Read(samples)
+		if n > 0 {
+			read += n
+			block := samples[:n]
+			if resampler != nil {
+				block = resampler.Process(block)
+			}
+			if err := d.Decode(block); err != nil {
+				log.Fatal(err)
+			}
+
+			s := d.Status()
+			if *period > 0.0 && float64(read) >= next || s.Locked != last.Locked || s.Modcod != last.Modcod {
+				printStatus(float64(read) / *sampleRate, s)
+				for next <= float64(read) && *period > 0.0 {
+					next += *period * *sampleRate
+				}
+			}
+			last = s
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				log.Print("partial sample at the end of the recording")
+				break
+			}
+			log.Fatal(err)
+		}
+	}
+
+	if err := d.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	s := d.Status()
+	if s.Modcod != 0 {
+		fmt.Fprintf(os.Stderr, "last PLFRAME %s, MER %.1f dB, SNR %.1f dB, EVM %.1f%%\n",
+			s.ModcodName(), s.Quality.Mer, s.Quality.Snr, s.Quality.EvmRms)
+	}
+	fmt.Fprintf(os.Stderr, "%d PLFRAMEs, %d dummy, %d lost, %d BCH corrections, %d sync losses, %d packets, %d CRC errors\n",
+		s.Frames, s.DummyFrames, s.FrameErrors, s.BchCorrected, s.SyncLosses, s.Packets, s.CrcErrors)
+}
+
+func printStatus(time float64, s dvb2s.DecoderStatus) {
+	state := "searching"
+	if s.Locked {
+		state = "locked"
+	}
+	if s.Modcod == 0 {
+		fmt.Fprintf(os.Stderr, "%8.3f s %s\n", time, state)
+		return
+	}
+
+	frame := "normal"
+	if s.ShortFrames {
+		frame = "short"
+	}
+	pilots := "no pilots"
+	if s.Pilots {
+		pilots = "pilots"
+	}
+	fmt.Fprintf(os.Stderr, "%8.3f s %s %s %s %s, MER %.1f dB, frames %d, lost %d, packets %d, CRC errors %d\n",
+		time, state, s.ModcodName(), frame, pilots, s.Quality.Mer, s.Frames, s.FrameErrors, s.Packets, s.CrcErrors)
+}
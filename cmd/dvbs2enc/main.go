@@ -0,0 +1,199 @@
+// Command dvbs2enc modulates a transport stream into DVB-S2 baseband IQ
+// samples. The stream is read from a file or stdin and samples of the unit mean
+// power go to a file or stdout, so they may be piped into SDR tools. Sample
+// rates not an integer multiple of the symbol rate are reached by resampling
+// the shaped signal. The recording may be described by a SigMF metadata file.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+
+	dvb2s "github.com/ivantaran/dvb2sgo"
+	"github.com/ivantaran/dvb2sgo/channel"
+)
+
+const spectrumSegment int = 1024
+const predistortionIterations int = 6
+
+func main() {
+	input := flag.String("input", "-", "transport stream file, - for stdin")
+	output := flag.String("output", "-", "IQ file, - for stdout")
+	modcodHelp, frameHelp := dvb2s.ModcodUsage()
+	modcod := flag.Int("modcod", 7, modcodHelp)
+	frame := flag.String("frame", "normal", frameHelp)
+	pilots := flag.Bool("pilots", false, "insert pilots")
+	rolloff := flag.Float64("rolloff", 0.35, "roll-off: 0.35, 0.25 or 0.20")
+	symbolRate := flag.Float64("symbolrate", 1e6, "symbol rate, Hz")
+	sampleRate := flag.Float64("samplerate", 2e6, "sample rate, Hz, at least twice the symbol rate")
+	gold := flag.Int("gold", 0, "gold code of PL scrambling")
+	format := flag.String("format", "cs16le", "sample format: cs8, cs16le, cs16be, cf32 or cf64")
+	backoff := flag.Float64("backoff", 6.0, "backoff of the mean power from the full scale, dB")
+	workers := flag.Int("workers", runtime.NumCPU(), "FEC encoding goroutines")
+	spectrum := flag.Bool("spectrum", false, "check the spectrum of output against the mask, print to stderr")
+	sigmf := flag.String("sigmf", "", "SigMF metadata file of output with PLFRAME annotations, empty for none")
+	predistortion := flag.String("predistortion", "", "static predistortion, radius factor:phase in degrees of rings from the inner one, like 1.1:-5,1:0")
+	twta := flag.Float64("twta", 0.0, "train dynamic predistortion for the Saleh TWTA at the input backoff, dB, 0 for none")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("dvbs2enc: ")
+
+	ratio := *sampleRate / *symbolRate
+	if !(ratio >= 2.0) || math.IsInf(ratio, 0) {
+		log.Fatal("sample rate must be at least twice the symbol rate")
+	}
+	oversampling := int(math.Round(ratio))
+	var resampler *channel.Resampler
+	if math.Abs(ratio-float64(oversampling)) > 1e-9*ratio {
+		oversampling = int(math.Max(4.0, math.Ceil(ratio)))
+		resampler = channel.NewResampler(float64(oversampling) / ratio)
+	}
+
+	e, err := dvb2s.NewEncoderModcod(*modcod, *frame, *pilots, oversampling, *workers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := e.SetRolloff(*rolloff); err != nil {
+		log.Fatal(err)
+	}
+	if err := e.SetGoldCode(*gold); err != nil {
+		log.Fatal(err)
+	}
+	if *predistortion != "" {
+		radius, phase, err := parsePredistortion(*predistortion)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := e.SetPredistortion(radius, phase); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *twta > 0.0 {
+		e.TrainPredistortion(channel.NewSalehTwta(), *twta, predistortionIterations)
+	}
+
+	var in io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	var out io.WriteCloser = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out = file
+	}
+
+	writer, err := dvb2s.NewSampleWriter(out, *format, *backoff)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var meta *dvb2s.SigmfMeta
+	if *sigmf != "" {
+		meta, err = dvb2s.NewSigmfMeta(*format, *sampleRate, *symbolRate, *rolloff)
+		if err != nil {
+			log.Fatal(err)
+		}
+		e.SetSigmfMeta(meta)
+	}
+
+	var welch *channel.Welch
+	if *spectrum {
+		welch = channel.NewWelch(spectrumSegment)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	scale := complex(float64(oversampling), 0.0)
+	err = e.Encode(ctx, in, func(samples []complex128) error {
+		for i := range samples {
+			samples[i] *= scale
+		}
+		if resampler != nil {
+			samples = resampler.Process(samples)
+		}
+		if welch != nil {
+			welch.Process(samples)
+		}
+		return writer.Write(samples)
+	})
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+
+	if meta != nil {
+		file, err := os.Create(*sigmf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := meta.Write(file); err != nil {
+			log.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if writer.Clipped() > 0 {
+		log.Printf("%d of %d I and Q values clipped, raise -backoff", writer.Clipped(), 2*writer.Samples())
+	}
+
+	if welch != nil {
+		s := dvb2s.CheckSpectrum(welch.Spectrum(), ratio, *rolloff)
+		result := "pass"
+		if !s.Pass() {
+			result = "FAIL"
+		}
+		fmt.Fprintf(os.Stderr, "occupied bandwidth 99%%: %.4f symbol rates, %.0f Hz\n", s.OccupiedBandwidth, s.OccupiedBandwidth**symbolRate)
+		fmt.Fprintf(os.Stderr, "ACPR: lower %.1f dB, upper %.1f dB\n", s.AcprLower, s.AcprUpper)
+		fmt.Fprintf(os.Stderr, "spectrum mask: %s, margin %.2f dB at %.0f Hz\n", result, s.MaskMargin, s.MaskFrequency**symbolRate)
+	}
+}
+
+// parsePredistortion reads radius factors and phases in degrees of rings like
+// "1.1:-5,1:0".
+func parsePredistortion(value string) ([]float64, []float64, error) {
+	rings := strings.Split(value, ",")
+	radius := make([]float64, len(rings))
+	phase := make([]float64, len(rings))
+	for i, ring := range rings {
+		fields := strings.Split(ring, ":")
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("predistortion of ring %d is not radius:phase", i)
+		}
+		var radiusErr, phaseErr error
+		radius[i], radiusErr = strconv.ParseFloat(fields[0], 64)
+		phase[i], phaseErr = strconv.ParseFloat(fields[1], 64)
+		if radiusErr != nil || phaseErr != nil {
+			return nil, nil, fmt.Errorf("predistortion of ring %d is not radius:phase", i)
+		}
+		phase[i] *= math.Pi / 180.0
+	}
+	return radius, phase, nil
+}
@@ -2,94 +2,157 @@ package dvb2s
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 )
 
 type dvb2s struct {
+	*frame
 	modcod              int
 	fecFrameType        int
 	oversampling        int
-	ldpcQFactor         int
-	bitsPerPlSymbol     int
+	constellation       *constellation
+	points              []complex128 // statically predistorted
+	plScrambling        []uint8
+	predistortion       *predistortion
 	interpolateByRepeat bool
-	inFrame             []bool
-	bbFrame             []bool
-	bchBlock            []bool
-	bchFec              []bool
-	ldpcFec             []bool
-	fecFrame            []bool
-	plFrame             []complex128 // TODO: combine plFrame with plHeader
-	plHeader            []complex128
-	outFrame            []complex128
+	inFrame             []byte
+	bchEncoder          *bchEncoder
+	ldpcEncoder         *ldpcEncoder
 	firFilter           *fir
 	bbHeader            *bbHeader
+	tsReader            *tsReader
+	tsPacket            []byte
+	userPacket          []byte
+	userPacketPointer   int
+	userPacketCrc       uint8
 }
 
 func newDvb2s(fecFrameType string, oversampling int, interpolateByRepeat bool) *dvb2s {
+	return newDvb2sModcod(7, fecFrameType, false, oversampling, interpolateByRepeat)
+}
+
+// newDvb2sModcod makes the encoder of the MODCOD, see SupportedModcods.
+func newDvb2sModcod(modcod int, fecFrameType string, pilots bool, oversampling int, interpolateByRepeat bool) *dvb2s {
 	var d dvb2s
 
 	fecFrameSize := fecFramesizeMap[fecFrameType]
 
-	// TODO : repair this
-	// enum {
-	// 	QPSK3_4 = 7
-	// };
-
-	// enum {
-	// 	PL_NORMAL = 0,
-	// 	PL_SMALL = 1,
-	// 	PL_NORMAL_P = 2,
-	// 	PL_SMALL_P = 3,
-	// };
-
+	d.modcod = modcod
 	d.fecFrameType = 0
-	d.modcod = 7
+	if fecFrameType == "small" {
+		d.fecFrameType |= 0x02
+	}
+	if pilots {
+		d.fecFrameType |= 0x01
+	}
 
 	d.oversampling = oversampling
-	bchBlockSize := fecFrameSize * 3 / 4
-	ldpcFecSize := fecFrameSize - bchBlockSize
-	bchTErrorCorrection := 12 // TODO: select from table 5a, 5b
-	bchFecSize := bchTErrorCorrection * (bchPolyNLength - 1)
-	bbFrameSize := bchBlockSize - bchFecSize
+	code := bchCodeMap[fecFrameType][modcodCodeRate[d.modcod]]
+	bchBlockSize := code.nbch
+	bbFrameSize := code.kbch
 
-	d.ldpcQFactor = ldpcFecSize / ldpcBlockSize
-	d.bitsPerPlSymbol = 2
+	d.constellation = newConstellation(d.modcod)
+	d.points = d.constellation.points
 
-	plFrameSize := fecFrameSize / d.bitsPerPlSymbol
-	outFrameSize := plFrameSize * d.oversampling
+	plFrameSize := fecFrameSize / d.constellation.bitsPerSymbol
+	if pilots {
+		plFrameSize += (plFrameSize/slotSize - 1) / pilotPeriod * pilotBlockSize
+	}
 
-	d.bbHeader = newBbHeader()
+	d.bbHeader = newBbHeader(bbFrameSize)
 
 	inFrameSize := d.bbHeader.getDataFieldLength()
-	d.inFrame = make([]bool, inFrameSize)
-
-	d.fecFrame = make([]bool, fecFrameSize)
-	d.bbFrame = d.fecFrame[:bbFrameSize]
-	d.bchBlock = d.fecFrame[:bchBlockSize]
-	d.bchFec = d.fecFrame[bbFrameSize:bchBlockSize]
-	d.ldpcFec = d.fecFrame[bchBlockSize:]
-	d.plFrame = make([]complex128, plFrameSize)
-	d.plHeader = make([]complex128, slotSize)
-	d.outFrame = make([]complex128, outFrameSize)
-
-	switch oversampling {
-	case 2:
-		// d.firFilter = newFir(firRrc2x035Table)
-		d.firFilter = newFir(firRrc2x035BigTable)
-	case 4:
-		d.firFilter = newFir(firRrc4x035Table)
-	default:
-		panic("unknown oversampling\n")
-	}
+	d.inFrame = make([]byte, inFrameSize/8)
+	d.tsPacket = make([]byte, tsPacketSize)
+	d.userPacket = make([]byte, d.bbHeader.getUserPacketLength()/8)
+	d.userPacketPointer = len(d.userPacket)
+
+	d.frame = newFrame(bbFrameSize, bchBlockSize, fecFrameSize, plFrameSize, d.oversampling)
+
+	d.plScrambling = plScrambleTable
+	d.setRolloff(0.35)
 
 	d.interpolateByRepeat = interpolateByRepeat
 
+	d.bchEncoder = newBchEncoder(bchInit(code.t, fecFrameType == "small"))
+	d.ldpcEncoder = newLdpcEncoder(fecFrameType, modcodCodeRate[d.modcod])
+
 	return &d
 }
 
+// SupportedModcods lists MODCODs of the frame type, normal or small, having
+// LDPC tables.
+func SupportedModcods(fecFrameType string) []int {
+	modcods := []int{}
+	for modcod := 1; modcod < len(modcodCodeRate); modcod++ {
+		if _, ok := ldpcTableMap[fecFrameType][modcodCodeRate[modcod]]; ok {
+			modcods = append(modcods, modcod)
+		}
+	}
+	return modcods
+}
+
+// ModcodName returns the name of the MODCOD like "QPSK 3/4".
+func ModcodName(modcod int) string {
+	if modcod < 0 || modcod >= len(modcodName) {
+		return fmt.Sprintf("MODCOD %d", modcod)
+	}
+	return modcodName[modcod]
+}
+
+// ModcodUsage returns the help of the MODCOD and of the FECFRAME size flags of
+// the commands. Only MODCODs having LDPC tables are listed, so far the 3/4
+// ones of normal frames.
+func ModcodUsage() (string, string) {
+	modcods := []string{}
+	frames := []string{}
+	for _, frame := range []string{"normal", "small"} {
+		supported := SupportedModcods(frame)
+		if len(supported) == 0 {
+			continue
+		}
+		names := make([]string, len(supported))
+		for i, modcod := range supported {
+			names[i] = fmt.Sprintf("%d (%s)", modcod, ModcodName(modcod))
+		}
+		modcods = append(modcods, frame+" frames: "+strings.Join(names, ", "))
+		frames = append(frames, frame)
+	}
+	return "MODCOD with an LDPC table, " + strings.Join(modcods, "; "), "FECFRAME size: " + strings.Join(frames, " or ")
+}
+
+// checkModcod tells why the MODCOD of the frame type can not be encoded.
+func checkModcod(modcod int, fecFrameType string) error {
+	if _, ok := fecFramesizeMap[fecFrameType]; !ok {
+		return fmt.Errorf("unknown FECFRAME size \"%s\"", fecFrameType)
+	}
+	if modcod <= 0 || modcod >= len(modcodCodeRate) {
+		return fmt.Errorf("unknown MODCOD %d", modcod)
+	}
+	if _, ok := ldpcTableMap[fecFrameType][modcodCodeRate[modcod]]; !ok {
+		return fmt.Errorf("unsupported MODCOD %s of %s frames", modcodName[modcod], fecFrameType)
+	}
+	return nil
+}
+
+// setRolloff sets the shaping filter and the RO field of BBHEADER, see
+// rolloffMap.
+func (d *dvb2s) setRolloff(rolloff float64) {
+	ro, ok := rolloffMap[rolloff]
+	if !ok {
+		panic("unsupported roll-off\n")
+	}
+
+	d.firFilter = newFir(firRrcTable(d.oversampling, rolloff))
+	d.bbHeader.matype1[0] = d.bbHeader.matype1[0]&^0x03 | ro
+	d.bbHeader.update()
+}
+
 func (d *dvb2s) LoadInputData(fileName string) error { //TODO: remove this method
 
 	file, err := os.Open(fileName)
@@ -109,9 +172,9 @@ func (d *dvb2s) LoadInputData(fileName string) error { //TODO: remove this metho
 			fmt.Printf("last line is: \"%s\"\n", scanner.Text())
 			break
 		}
-		d.bbFrame[i] = value > 0
+		setBit(d.bbFrame, i, value > 0)
 		i++
-		if i == len(d.bbFrame) {
+		if i == len(d.bbFrame)*8 {
 			break
 		}
 	}
@@ -138,15 +201,15 @@ func (d *dvb2s) LoadInputFrame(fileName string) error {
 			fmt.Printf("last line is: \"%s\"\n", scanner.Text())
 			return err
 		}
-		d.inFrame[i] = value > 0
+		setBit(d.inFrame, i, value > 0)
 		i++
-		if i == len(d.inFrame) {
+		if i == len(d.inFrame)*8 {
 			break
 		}
 	}
 
-	if i != len(d.inFrame) {
-		return fmt.Errorf("incorrect frame length: %d != %d", i, len(d.inFrame))
+	if i != len(d.inFrame)*8 {
+		return fmt.Errorf("incorrect frame length: %d != %d", i, len(d.inFrame)*8)
 	}
 
 	d.crc8Encode()
@@ -154,132 +217,192 @@ func (d *dvb2s) LoadInputFrame(fileName string) error {
 	return nil
 }
 
-func (d *dvb2s) bbFrameScramble() {
-	init := 0x4a80
-
-	sr := init
-	for i := range d.bbFrame {
-		fb := ((sr << 14) ^ (sr << 13)) & 0x4000
-		d.bbFrame[i] = d.bbFrame[i] != (fb > 0)
-		sr = ((sr >> 1) & 0x3fff) | fb
-	}
+// SetInputStream selects a binary MPEG-TS stream of 188 or 204 bytes packets
+// as the source of LoadInputStream.
+func (d *dvb2s) SetInputStream(reader io.Reader) {
+	d.tsReader = newTsReader(reader)
+	d.userPacketPointer = len(d.userPacket)
+	d.userPacketCrc = 0
 }
 
-func (d *dvb2s) bchPolymul(a [bchPolyNLength]bool, b []bool, lenb int, r []bool) int {
+// LoadInputStream fills the next BBFRAME with user packets of the input stream.
+// A packet crossing the frame boundary is continued in the next frame, the last
+// frame of the stream is padded. io.EOF is returned when no data is left.
+func (d *dvb2s) LoadInputStream() error {
+	if d.tsReader == nil {
+		return errors.New("input stream is not set")
+	}
 
-	var len int
+	syncd := -1
+	i := 0
+	for i < len(d.inFrame) {
+		if d.userPacketPointer == len(d.userPacket) {
+			err := d.loadUserPacket()
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if d.userPacketPointer == 0 && syncd < 0 {
+			syncd = i
+		}
+		n := copy(d.inFrame[i:], d.userPacket[d.userPacketPointer:])
+		d.userPacketPointer += n
+		i += n
+	}
 
-	for i := 0; i < bchPolyNLength+lenb; i++ {
-		r[i] = false
+	if i == 0 {
+		return io.EOF
 	}
 
-	for j := 0; j < lenb; j++ {
-		for i := 0; i < bchPolyNLength; i++ {
-			r[i+j] = r[i+j] != (a[i] && b[j])
-		}
+	for j := i; j < len(d.inFrame); j++ {
+		d.inFrame[j] = 0x00
 	}
 
-	for i := 0; i < bchPolyNLength+lenb; i++ {
-		if r[i] {
-			len = i
-		}
+	if syncd < 0 {
+		syncd = 0xffff
+	} else {
+		syncd *= 8
 	}
 
-	return len + 1
+	d.bbHeader.setDataFieldLength(i * 8)
+	d.bbHeader.setDataFieldToUserPacketDistance(syncd)
+	d.bbHeader.update()
+
+	copy(d.bbFrame, d.bbHeader.bytes[:])
+	copy(d.bbFrame[len(d.bbHeader.bytes):], d.inFrame)
+
+	return nil
 }
 
-func (d *dvb2s) bchInit(gpoly []bool) int {
+// loadUserPacket reads the next TS packet and replaces its sync byte with
+// CRC-8 of the previous packet.
+func (d *dvb2s) loadUserPacket() error {
+	err := d.tsReader.readPacket(d.tsPacket)
+	if err != nil {
+		return err
+	}
 
-	gpoly1 := make([]bool, len(gpoly))
+	d.userPacket[0] = d.userPacketCrc
+	copy(d.userPacket[1:], d.tsPacket[1:])
 
-	ll := d.bchPolymul(bchPolyN[0], bchPolyN[1][:], bchPolyNLength, gpoly1)
+	d.userPacketCrc = d.bbHeader.crc8Encode(d.userPacket[1:])
+	d.userPacketPointer = 0
 
-	p1 := gpoly1
-	p2 := gpoly
+	return nil
+}
 
-	for i := 2; i < len(bchPolyN); i++ { //TODO: replace len(bchPolyN) to parameter = [8, 10, 12]
-		ll = d.bchPolymul(bchPolyN[i], p1, ll, p2)
-		p1, p2 = p2, p1
+func (d *dvb2s) bbFrameScramble() {
+	for i := range d.bbFrame {
+		d.bbFrame[i] ^= bbScrambleTable[i]
 	}
+}
 
-	for i := 0; i < ll; i++ {
-		gpoly[i] = gpoly1[ll-i-1]
+// bbFrameDescramble reverses bbFrameScramble of a received BBFRAME.
+func bbFrameDescramble(bbFrame []byte) {
+	for i := range bbFrame {
+		bbFrame[i] ^= bbScrambleTable[i]
 	}
-
-	return ll
 }
 
-func (d *dvb2s) bchEncode(gpoly []bool) {
+func newBbScrambleTable(size int) []byte {
+	table := make([]byte, size)
+	init := 0x4a80
 
-	for i := range d.bchFec {
-		d.bchFec[i] = false
+	sr := init
+	for i := 0; i < size*8; i++ {
+		fb := ((sr << 14) ^ (sr << 13)) & 0x4000
+		setBit(table, i, fb > 0)
+		sr = ((sr >> 1) & 0x3fff) | fb
 	}
 
-	len := len(d.bchFec)
-	for _, value := range d.bbFrame {
-		fb := d.bchFec[0] != value
-		for i := 0; i < len-1; i++ {
-			d.bchFec[i] = (gpoly[i+1] && fb) != d.bchFec[i+1] // TODO: length gpoly must be 192, not 193
-		}
-		d.bchFec[len-1] = fb
-	}
+	return table
+}
 
+func (d *dvb2s) bchEncode() {
+	d.bchEncoder.encode(d.bbFrame, d.bchFec)
 }
 
 func (d *dvb2s) ldpcEncode() {
-	for j, row := range ldpcTable3_4 {
-		for i := 0; i < ldpcBlockSize; i++ {
-			for _, value := range row {
-				addr := (int(value) + i*d.ldpcQFactor) % len(d.ldpcFec)
-				d.ldpcFec[addr] = d.ldpcFec[addr] != d.bchBlock[i+j*ldpcBlockSize]
-			}
-		}
-	}
-
-	for i := 1; i < len(d.ldpcFec); i++ {
-		d.ldpcFec[i] = d.ldpcFec[i] != d.ldpcFec[i-1]
-	}
+	d.ldpcEncoder.encode(d.bchBlock, d.ldpcFec)
 }
 
+// bitInterleave writes FECFRAME bits column-wise into bitsPerSymbol columns
+// and reads them row-wise, QPSK is not interleaved.
 func (d *dvb2s) bitInterleave() {
+	m := d.constellation.bitsPerSymbol
+	if m == 2 {
+		copy(d.bitFrame, d.fecFrame)
+		return
+	}
 
+	rows := len(d.fecFrame) * 8 / m
+	reverse := d.modcod == 12 // 8PSK 3/5
+	for row := 0; row < rows; row++ {
+		for k := 0; k < m; k++ {
+			column := k
+			if reverse {
+				column = m - 1 - k
+			}
+			setBit(d.bitFrame, row*m+k, getBit(d.fecFrame, column*rows+row))
+		}
+	}
 }
 
+// mapIntoConstellation maps interleaved bits, pilot blocks go after every 16
+// slots of data.
 func (d *dvb2s) mapIntoConstellation() {
-
-	for i, j := 0, 0; i < len(d.fecFrame); i, j = i+d.bitsPerPlSymbol, j+1 {
+	m := d.constellation.bitsPerSymbol
+	pilot := complex(map1Pi4, map1Pi4)
+
+	for i, j := 0, 0; i < len(d.bitFrame)*8; i, j = i+m, j+1 {
+		if d.hasPilots() && j > 0 && j%(pilotPeriod*slotSize) == 0 {
+			n := d.plFramePosition(j) - pilotBlockSize
+			for k := 0; k < pilotBlockSize; k++ {
+				d.plFrame[n+k] = pilot
+			}
+		}
 
 		position := 0
-		if d.fecFrame[i] {
-			position |= 2
-		}
-		if d.fecFrame[i+1] {
-			position |= 1
+		for k := 0; k < m; k++ {
+			position <<= 1
+			if getBit(d.bitFrame, i+k) {
+				position |= 1
+			}
 		}
 
-		switch position {
-		case 0x00:
-			d.plFrame[j] = complex(map1Pi4, map1Pi4)
-		case 0x01:
-			d.plFrame[j] = complex(map1Pi4, -map1Pi4)
-		case 0x02:
-			d.plFrame[j] = complex(-map1Pi4, map1Pi4)
-		case 0x03:
-			d.plFrame[j] = complex(-map1Pi4, -map1Pi4)
-		default:
-			panic("unkwown symbol in mapper\n")
-		}
+		d.labels[j] = uint8(position)
+		d.plFrame[d.plFramePosition(j)] = d.points[position]
+	}
+
+	if d.predistortion != nil && d.predistortion.table != nil {
+		d.predistortion.apply(d)
 	}
 }
 
+// plFramePosition returns the position of the data symbol j in plFrame.
+func (d *dvb2s) plFramePosition(j int) int {
+	if d.hasPilots() {
+		return j + j/(pilotPeriod*slotSize)*pilotBlockSize
+	}
+	return j
+}
+
 func (d *dvb2s) plHeaderEncode() {
+	plHeaderMap(d.modcod, d.fecFrameType, d.plHeader)
+}
+
+// plHeaderMap writes 90 symbols of PLHEADER of the MODCOD and the frame type.
+func plHeaderMap(modcod int, fecFrameType int, symbols []complex128) {
 	var plHeaderInt int
-	isDvbs2x := (d.modcod & 0x80) > 0
+	isDvbs2x := (modcod & 0x80) > 0
 
 	if isDvbs2x {
-		plHeaderInt = d.modcod | (d.fecFrameType & 0x01)
+		plHeaderInt = modcod | (fecFrameType & 0x01)
 	} else {
-		plHeaderInt = (d.modcod << 2) | (d.fecFrameType & 0x03)
+		plHeaderInt = (modcod << 2) | (fecFrameType & 0x03)
 	}
 
 	x := 0
@@ -289,8 +412,8 @@ func (d *dvb2s) plHeaderEncode() {
 		}
 	}
 
-	plHeader := make([]bool, slotSize)
-	copy(plHeader, plHeaderSof)
+	var plHeader [slotSize]bool
+	copy(plHeader[:], plHeaderSof)
 	plHeaderScrambled := plHeader[len(plHeaderSof):]
 	plHeaderBit := (plHeaderInt & 0x01) > 0
 	m := 1 << uint(len(plHeaderScrambled)/2-1)
@@ -302,28 +425,61 @@ func (d *dvb2s) plHeaderEncode() {
 		m >>= 1
 	}
 
-	for i := 0; i < len(d.plHeader); i += 2 {
-		if plHeader[i] {
-			d.plHeader[i] = complex(-map1Pi4, -map1Pi4)
-		} else {
-			d.plHeader[i] = complex(map1Pi4, map1Pi4)
-		}
-		if plHeader[i+1] {
-			d.plHeader[i+1] = complex(map1Pi4, -map1Pi4)
-		} else {
-			d.plHeader[i+1] = complex(-map1Pi4, map1Pi4)
-		}
+	for i, bit := range plHeader {
+		symbols[i] = plHeaderSymbol(bit, i)
 	}
 }
 
+// plHeaderSymbol maps a bit of PLHEADER to pi/2 BPSK.
+func plHeaderSymbol(bit bool, i int) complex128 {
+	value := complex(map1Pi4, map1Pi4)
+	if i%2 > 0 {
+		value = complex(-map1Pi4, map1Pi4)
+	}
+	if bit {
+		return -value
+	}
+	return value
+}
+
 func (d *dvb2s) plScramble() {
+	for i, r := range d.plScrambling[:len(d.plFrame)] {
+		d.plFrame[i] = plRotate(d.plFrame[i], r)
+	}
+}
+
+// plRotate turns value by r*pi/2.
+func plRotate(value complex128, r uint8) complex128 {
+	switch r & 0x03 {
+	case 0x01:
+		return complex(-imag(value), real(value))
+	case 0x02:
+		return -value
+	case 0x03:
+		return complex(imag(value), -real(value))
+	}
+	return value
+}
+
+// plGoldCodes is the period of the x sequence of PL scrambling.
+const plGoldCodes int = 1<<18 - 1
+
+// newPlScrambleTable returns the PL scrambling sequence of the gold code n,
+// the x sequence starts n steps on.
+func newPlScrambleTable(size int, n int) []uint8 {
+	table := make([]uint8, size)
 	initX := 0x00001
 	initY := 0x3ffff
 
 	srx := initX
 	sry := initY
 
-	for i := range d.plFrame {
+	for i := 0; i < n; i++ {
+		fbx := (srx >> 0) ^ (srx >> 7)
+		srx = ((srx >> 1) & 0x1ffff) | (fbx << 17)
+	}
+
+	for i := range table {
 		fbx := (srx >> 0) ^ (srx >> 7)
 		fby := (sry >> 0) ^ (sry >> 5) ^ (sry >> 7) ^ (sry >> 10)
 
@@ -332,48 +488,34 @@ func (d *dvb2s) plScramble() {
 			(sry >> 10) ^ (sry >> 11) ^ (sry >> 12) ^ (sry >> 13) ^
 			(sry >> 14) ^ (sry >> 15)
 
-		r := (((srx ^ sry) & 1) | ((zx ^ zy) << 1)) & 0x03
+		table[i] = uint8((((srx ^ sry) & 1) | ((zx ^ zy) << 1)) & 0x03)
 
 		srx = ((srx >> 1) & 0x1ffff) | (fbx << 17)
 		sry = ((sry >> 1) & 0x1ffff) | (fby << 17)
+	}
 
-		switch r {
-		case 0x00:
-		case 0x01:
-			d.plFrame[i] = complex(-imag(d.plFrame[i]), real(d.plFrame[i]))
-		case 0x02:
-			d.plFrame[i] = -d.plFrame[i]
-		case 0x03:
-			d.plFrame[i] = complex(imag(d.plFrame[i]), -real(d.plFrame[i]))
-		default:
-			panic("unkwown symbol in scrambler\n")
-		}
+	return table
+}
+
+// plGoldScrambling returns the PL scrambling sequence of the gold code n.
+func plGoldScrambling(n int) ([]uint8, error) {
+	if n < 0 || n >= plGoldCodes {
+		return nil, fmt.Errorf("gold code %d is out of 0 to %d", n, plGoldCodes-1)
+	}
+	if n == 0 {
+		return plScrambleTable, nil
 	}
+	return newPlScrambleTable(plFrameMaxSize, n), nil
 }
 
-func (d *dvb2s) outInterpolateBbShape() { // TODO: preload and push forward the filter
+// outInterpolateBbShape keeps the filter state from frame to frame, so frames
+// make one continuous signal.
+func (d *dvb2s) outInterpolateBbShape() {
 	scale := 1.0 / float64(d.oversampling)
 
-	if d.interpolateByRepeat {
-		for _, value := range d.plFrame {
-			value = complex(real(value)*scale, imag(value)*scale)
-			for i := 0; i < d.oversampling; i++ {
-				d.firFilter.fir(value)
-			}
-		}
-	} else {
-		nullValue := complex(0.0, 0.0)
-		for _, value := range d.plFrame[len(d.plFrame)-len(firRrc2x035BigTable)/d.oversampling/2:] {
-			d.firFilter.fir(value)
-			for i := 1; i < d.oversampling; i++ {
-				d.firFilter.fir(nullValue)
-			}
-		}
-	}
-
 	j := 0
 	if d.interpolateByRepeat {
-		for _, value := range d.plFrame {
+		for _, value := range d.plSymbols {
 			value = complex(real(value)*scale, imag(value)*scale)
 			for i := 0; i < d.oversampling; i++ {
 				d.outFrame[j] = d.firFilter.fir(value)
@@ -382,7 +524,7 @@ func (d *dvb2s) outInterpolateBbShape() { // TODO: preload and push forward the
 		}
 	} else {
 		nullValue := complex(0.0, 0.0)
-		for _, value := range d.plFrame {
+		for _, value := range d.plSymbols {
 			d.outFrame[j] = d.firFilter.fir(value)
 			j++
 			for i := 1; i < d.oversampling; i++ {
@@ -399,16 +541,43 @@ func (d *dvb2s) crc8Encode() {
 		return
 	}
 
-	upl := d.bbHeader.getUserPacketLength()
+	upl := d.bbHeader.getUserPacketLength() / 8
 
-	for upPointer := 0; upPointer < len(d.inFrame)-upl-8; upPointer += upl {
-		up := d.inFrame[upPointer+8 : upPointer+upl]
-		upCrc := d.inFrame[upPointer+upl : upPointer+upl+8]
-		crc := d.bbHeader.crc8Encode(up)
-		for i := 0; i < 8; i++ {
-			upCrc[i] = crc&0x01 > 0
-			crc >>= 1
-		}
+	for upPointer := 0; upPointer < len(d.inFrame)-upl-1; upPointer += upl {
+		up := d.inFrame[upPointer+1 : upPointer+upl]
+		d.inFrame[upPointer+upl] = d.bbHeader.crc8Encode(up)
 	}
 
 }
+
+// encodeFrame runs the loaded BBFRAME through FEC encoding, mapping, PL framing
+// and shaping into outFrame.
+func (d *dvb2s) encodeFrame() {
+	d.bbFrameScramble()
+	d.bchEncode()
+	d.ldpcEncode()
+	d.bitInterleave()
+	d.mapIntoConstellation()
+	d.plHeaderEncode()
+	d.plScramble()
+	d.outInterpolateBbShape()
+}
+
+func (d *dvb2s) isShortFrame() bool {
+	return d.fecFrameType&0x02 > 0
+}
+
+func (d *dvb2s) hasPilots() bool {
+	return d.fecFrameType&0x01 > 0
+}
+
+// spectralEfficiency returns bits of the data field per PLFRAME symbol, table
+// 13, for the Eb/N0 of the channel.
+func (d *dvb2s) spectralEfficiency() float64 {
+	return float64(len(d.bbFrame)*8-bbHeaderSize*8) / float64(len(d.plSymbols))
+}
+
+// outFrameDelay returns the position of the first PLHEADER sample in outFrame.
+func (d *dvb2s) outFrameDelay() int {
+	return d.firFilter.delay()
+}
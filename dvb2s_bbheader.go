@@ -1,5 +1,10 @@
 package dvb2s
 
+import (
+	"errors"
+	"fmt"
+)
+
 const (
 	GenericStreamPacketized = uint8(0x00)
 	GenericStreamContinuous = uint8(0x40)
@@ -22,8 +27,10 @@ const (
 	TransmissionRolloffFactor020 = uint8(0x02)
 )
 
+const bbHeaderSize int = 10
+
 type bbHeader struct {
-	bytes                         [10]uint8
+	bytes                         [bbHeaderSize]uint8
 	matype1                       []uint8
 	matype2                       []uint8
 	userPacketLength              []uint8
@@ -31,10 +38,10 @@ type bbHeader struct {
 	userPacketSyncByte            []uint8
 	dataFieldToUserPacketDistance []uint8
 	crc8                          []uint8
-	bitstream                     [80]bool
 }
 
-func newBbHeader() *bbHeader {
+// newBbHeader makes the header of a BBFRAME of kbch bits filled with data.
+func newBbHeader(kbch int) *bbHeader {
 	var h bbHeader
 
 	h.matype1 = h.bytes[0:1]
@@ -52,7 +59,7 @@ func newBbHeader() *bbHeader {
 	h.userPacketLength[0] = uint8(upl >> 8)
 	h.userPacketLength[1] = uint8(upl)
 
-	dfl := 48408 - len(h.bitstream)
+	dfl := kbch - len(h.bytes)*8
 	h.dataFieldLength[0] = uint8(dfl >> 8)
 	h.dataFieldLength[1] = uint8(dfl)
 
@@ -61,45 +68,42 @@ func newBbHeader() *bbHeader {
 	h.dataFieldToUserPacketDistance[0] = uint8(0x00)
 	h.dataFieldToUserPacketDistance[1] = uint8(0x00)
 
-	j := 0
-	for _, b := range h.bytes {
-		for i := 0; i < 8; i++ {
-			h.bitstream[j] = (b & uint8(0x80)) > 0
-			b <<= 1
-			j++
-		}
-	}
+	h.update()
+
+	return &h
+}
 
-	h.crc8[0] = h.crc8Encode(h.bitstream[:len(h.bitstream)-8])
+func (h *bbHeader) update() {
+	h.crc8[0] = h.crc8Encode(h.bytes[:len(h.bytes)-1])
+}
 
-	for i, sr := len(h.bitstream)-8, h.crc8[0]; i < len(h.bitstream); i, sr = i+1, sr>>1 {
-		h.bitstream[i] = (sr & 0x01) > 0
+func newCrc8Table(poly uint8) []uint8 {
+	table := make([]uint8, 256)
+
+	for i := range table {
+		sr := uint8(i)
+		for j := 0; j < 8; j++ {
+			if sr&0x80 > 0 {
+				sr = (sr << 1) ^ poly
+			} else {
+				sr <<= 1
+			}
+		}
+		table[i] = sr
 	}
 
-	return &h
+	return table
 }
 
-func (h *bbHeader) crc8Encode(data []bool) uint8 {
-	// poly := uint8(0x57)
-	// sr := uint8(0x00)
-
-	// for _, value := range data {
-	// 	if (sr&0x01 > 0) != value {
-	// 		sr = ((sr ^ poly) >> 1) | 0x80
-	// 	} else {
-	// 		sr >>= 1
-	// 	}
-	// }
+func (h *bbHeader) crc8Encode(data []byte) uint8 {
+	return crc8Checksum(data)
+}
 
-	poly := uint8(0xab)
+func crc8Checksum(data []byte) uint8 {
 	sr := uint8(0x00)
 
 	for _, value := range data {
-		swap := (sr&0x01 > 0) != value
-		sr >>= 1
-		if swap {
-			sr ^= poly
-		}
+		sr = crc8Table[sr^value]
 	}
 
 	return sr
@@ -112,3 +116,68 @@ func (h *bbHeader) getUserPacketLength() int {
 func (h *bbHeader) getDataFieldLength() int {
 	return int((uint16(h.dataFieldLength[0]) << 8) | uint16(h.dataFieldLength[1]))
 }
+
+func (h *bbHeader) setDataFieldLength(dfl int) {
+	h.dataFieldLength[0] = uint8(dfl >> 8)
+	h.dataFieldLength[1] = uint8(dfl)
+}
+
+func (h *bbHeader) getDataFieldToUserPacketDistance() int {
+	return int((uint16(h.dataFieldToUserPacketDistance[0]) << 8) | uint16(h.dataFieldToUserPacketDistance[1]))
+}
+
+func (h *bbHeader) setDataFieldToUserPacketDistance(syncd int) {
+	h.dataFieldToUserPacketDistance[0] = uint8(syncd >> 8)
+	h.dataFieldToUserPacketDistance[1] = uint8(syncd)
+}
+
+// ErrBbHeaderCrc is returned for a BBHEADER failing the CRC-8 check.
+var ErrBbHeaderCrc = errors.New("BBHEADER CRC-8 mismatch")
+
+// BbHeader is the parsed BBHEADER of a received BBFRAME. StreamType, Rolloff
+// and the flags of MATYPE-1 compare to the constants of the MATYPE fields.
+type BbHeader struct {
+	StreamType               uint8
+	SingleInputStream        bool
+	ConstantCodingModulation bool
+	InputStreamSync          bool
+	NullPacketDeletion       bool
+	Rolloff                  uint8
+	InputStreamIdentifier    uint8 // MATYPE-2
+	UserPacketLength         int   // UPL, bits
+	DataFieldLength          int   // DFL, bits
+	SyncByte                 uint8 // SYNC
+	SyncDistance             int   // SYNCD, bits
+}
+
+// ParseBbHeader parses the BBHEADER of a descrambled BBFRAME and checks the
+// data field fits the frame.
+func ParseBbHeader(bbFrame []byte) (*BbHeader, error) {
+	var h BbHeader
+
+	if len(bbFrame) < bbHeaderSize {
+		return nil, fmt.Errorf("BBFRAME is too short: %d bytes", len(bbFrame))
+	}
+	if crc8Checksum(bbFrame[:bbHeaderSize-1]) != bbFrame[bbHeaderSize-1] {
+		return nil, ErrBbHeaderCrc
+	}
+
+	matype1 := bbFrame[0]
+	h.StreamType = matype1 & TransportStream
+	h.SingleInputStream = matype1&SingleInputStream > 0
+	h.ConstantCodingModulation = matype1&ConstantCodingModulation > 0
+	h.InputStreamSync = matype1&IStreamSyncIndicatorYes > 0
+	h.NullPacketDeletion = matype1&NullPacketDeletionYes > 0
+	h.Rolloff = matype1 & 0x03
+	h.InputStreamIdentifier = bbFrame[1]
+	h.UserPacketLength = int(bbFrame[2])<<8 | int(bbFrame[3])
+	h.DataFieldLength = int(bbFrame[4])<<8 | int(bbFrame[5])
+	h.SyncByte = bbFrame[6]
+	h.SyncDistance = int(bbFrame[7])<<8 | int(bbFrame[8])
+
+	if h.DataFieldLength > (len(bbFrame)-bbHeaderSize)*8 {
+		return nil, fmt.Errorf("DFL %d exceeds the data field of %d bits", h.DataFieldLength, (len(bbFrame)-bbHeaderSize)*8)
+	}
+
+	return &h, nil
+}
@@ -0,0 +1,377 @@
+package dvb2s
+
+import (
+	"errors"
+	"sync"
+)
+
+// bchCode holds the BCH parameters of a code rate, tables 5a and 5b.
+type bchCode struct {
+	kbch int
+	nbch int
+	t    int
+}
+
+type bchGpolyKey struct {
+	t          int
+	shortFrame bool
+}
+
+var (
+	bchGpolyCache      = map[bchGpolyKey][]bool{}
+	bchGpolyCacheMutex sync.Mutex
+)
+
+func bchPolymul(a []bool, b []bool) []bool {
+	r := make([]bool, len(a)+len(b)-1)
+
+	for j := range b {
+		for i := range a {
+			r[i+j] = r[i+j] != (a[i] && b[j])
+		}
+	}
+
+	return r
+}
+
+// bchInit returns the generator polynomial of the t-error correcting code as
+// the product of the first t minimal polynomials, the coefficient of the
+// highest degree goes first. Polynomials are computed once per code.
+func bchInit(t int, shortFrame bool) []bool {
+	bchGpolyCacheMutex.Lock()
+	defer bchGpolyCacheMutex.Unlock()
+
+	key := bchGpolyKey{t, shortFrame}
+	if gpoly, ok := bchGpolyCache[key]; ok {
+		return gpoly
+	}
+
+	polys := bchPolyN
+	if shortFrame {
+		polys = bchPolyS
+	}
+	if t > len(polys) {
+		panic("unsupported BCH error correction capability\n")
+	}
+
+	gpoly := []bool{true}
+	for _, poly := range polys[:t] {
+		gpoly = bchPolymul(poly, gpoly)
+	}
+
+	for i, j := 0, len(gpoly)-1; i < j; i, j = i+1, j-1 {
+		gpoly[i], gpoly[j] = gpoly[j], gpoly[i]
+	}
+
+	bchGpolyCache[key] = gpoly
+
+	return gpoly
+}
+
+// bchEncoder keeps the parity register of the generator polynomial in 64 bits
+// words, the first parity bit is the MSB of the first word. The table holds
+// the register after one byte of input from the zero state, so a byte of the
+// BBFRAME is encoded with one shift and one xor.
+type bchEncoder struct {
+	parityLength int
+	gpoly        []uint64
+	table        []uint64
+	register     []uint64
+}
+
+// newBchEncoder takes the generator polynomial in the order of bchInit,
+// gpoly[0] is the coefficient of the highest degree.
+func newBchEncoder(gpoly []bool) *bchEncoder {
+	var b bchEncoder
+
+	parityLength := len(gpoly) - 1
+	words := (parityLength + 63) / 64
+
+	b.parityLength = parityLength
+	b.gpoly = make([]uint64, words)
+	b.register = make([]uint64, words)
+	for i := 0; i < parityLength; i++ {
+		if gpoly[i+1] {
+			b.gpoly[i/64] |= 1 << uint(63-i%64)
+		}
+	}
+
+	b.table = make([]uint64, 256*words)
+	for i := 0; i < 256; i++ {
+		b.clear()
+		b.shiftSerial(uint8(i))
+		copy(b.table[i*words:(i+1)*words], b.register)
+	}
+
+	return &b
+}
+
+// clone returns an encoder of the same code with its own register.
+func (b *bchEncoder) clone() *bchEncoder {
+	c := *b
+	c.register = make([]uint64, len(b.register))
+	return &c
+}
+
+func (b *bchEncoder) clear() {
+	for i := range b.register {
+		b.register[i] = 0
+	}
+}
+
+func (b *bchEncoder) shiftSerial(value uint8) {
+	sr := b.register
+	last := len(sr) - 1
+
+	for k := 7; k >= 0; k-- {
+		fb := (sr[0] >> 63) ^ uint64(value>>uint(k)&0x01)
+		for i := 0; i < last; i++ {
+			sr[i] = (sr[i] << 1) | (sr[i+1] >> 63)
+		}
+		sr[last] <<= 1
+		if fb > 0 {
+			for i := range sr {
+				sr[i] ^= b.gpoly[i]
+			}
+		}
+	}
+}
+
+func (b *bchEncoder) shift(value uint8) {
+	sr := b.register
+	last := len(sr) - 1
+	words := len(sr)
+
+	remainder := b.table[int(uint8(sr[0]>>56)^value)*words:]
+	for i := 0; i < last; i++ {
+		sr[i] = (sr[i] << 8) | (sr[i+1] >> 56)
+		sr[i] ^= remainder[i]
+	}
+	sr[last] = (sr[last] << 8) ^ remainder[last]
+}
+
+// encode writes parityLength/8 bytes of parity of data.
+func (b *bchEncoder) encode(data []byte, parity []byte) {
+	b.clear()
+	for _, value := range data {
+		b.shift(value)
+	}
+	b.output(parity)
+}
+
+// encodeSerial is the bit by bit reference of encode.
+func (b *bchEncoder) encodeSerial(data []byte, parity []byte) {
+	b.clear()
+	for _, value := range data {
+		b.shiftSerial(value)
+	}
+	b.output(parity)
+}
+
+func (b *bchEncoder) output(parity []byte) {
+	for i := range parity[:b.parityLength/8] {
+		parity[i] = byte(b.register[i/8] >> uint(56-8*(i%8)))
+	}
+}
+
+var errBchUncorrectable = errors.New("uncorrectable BCH codeword")
+
+// bchDecoder corrects up to t errors of a codeword of the encoder. Syndromes
+// are computed from the remainder of the codeword, the xor of the received
+// parity and the parity of the received data, the error locator is found by
+// the Berlekamp-Massey algorithm and its roots by the Chien search.
+type bchDecoder struct {
+	encoder   *bchEncoder
+	t         int
+	exp       []int // exp[i] = alpha^i, twice the order of the field
+	log       []int
+	parity    []byte
+	syndromes []int
+	locator   []int
+	previous  []int
+	scratch   []int
+}
+
+func newBchDecoder(t int, shortFrame bool) *bchDecoder {
+	var b bchDecoder
+
+	b.encoder = newBchEncoder(bchInit(t, shortFrame))
+	b.t = t
+
+	// the first minimal polynomial is primitive, it makes the field
+	poly := bchPolyN[0]
+	if shortFrame {
+		poly = bchPolyS[0]
+	}
+	m := len(poly) - 1
+	order := 1<<uint(m) - 1
+	field := 0
+	for i, value := range poly {
+		if value {
+			field |= 1 << uint(i)
+		}
+	}
+
+	b.exp = make([]int, 2*order)
+	b.log = make([]int, order+1)
+	x := 1
+	for i := 0; i < order; i++ {
+		b.exp[i] = x
+		b.exp[i+order] = x
+		b.log[x] = i
+		x <<= 1
+		if x > order {
+			x ^= field
+		}
+	}
+
+	b.parity = make([]byte, b.encoder.parityLength/8)
+	b.syndromes = make([]int, 2*t+1)
+	b.locator = make([]int, 2*t+1)
+	b.previous = make([]int, 2*t+1)
+	b.scratch = make([]int, 2*t+1)
+
+	return &b
+}
+
+// clone returns a decoder of the same code with its own buffers.
+func (b *bchDecoder) clone() *bchDecoder {
+	c := *b
+	c.encoder = b.encoder.clone()
+	c.parity = make([]byte, len(b.parity))
+	c.syndromes = make([]int, len(b.syndromes))
+	c.locator = make([]int, len(b.locator))
+	c.previous = make([]int, len(b.previous))
+	c.scratch = make([]int, len(b.scratch))
+	return &c
+}
+
+func (b *bchDecoder) mul(x int, y int) int {
+	if x == 0 || y == 0 {
+		return 0
+	}
+	return b.exp[b.log[x]+b.log[y]]
+}
+
+func (b *bchDecoder) div(x int, y int) int {
+	if x == 0 {
+		return 0
+	}
+	return b.exp[b.log[x]+len(b.log)-1-b.log[y]]
+}
+
+// decode corrects the codeword of nbch bits in place and returns the number of
+// corrected bits.
+func (b *bchDecoder) decode(codeword []byte) (int, error) {
+	k := len(codeword) - len(b.parity)
+	b.encoder.encode(codeword[:k], b.parity)
+
+	clean := true
+	for i, value := range codeword[k:] {
+		b.parity[i] ^= value
+		if b.parity[i] != 0x00 {
+			clean = false
+		}
+	}
+	if clean {
+		return 0, nil
+	}
+
+	b.syndrome()
+	degree := b.berlekampMassey()
+	if degree > b.t {
+		return 0, errBchUncorrectable
+	}
+
+	positions := b.scratch[:0]
+	order := len(b.log) - 1
+	n := len(codeword) * 8
+	for d := 0; d < n && len(positions) < degree; d++ {
+		// locator(alpha^-d)
+		sum := 1
+		for i := 1; i <= degree; i++ {
+			if b.locator[i] != 0 {
+				sum ^= b.exp[(b.log[b.locator[i]]+(order-d%order)*i)%order]
+			}
+		}
+		if sum == 0 {
+			positions = append(positions, n-1-d)
+		}
+	}
+	if len(positions) != degree {
+		return 0, errBchUncorrectable
+	}
+
+	for _, position := range positions {
+		flipBit(codeword, position)
+	}
+
+	return degree, nil
+}
+
+// syndrome evaluates the remainder at alpha^j, j = 1..2t, the first bit of the
+// remainder is the coefficient of the highest degree.
+func (b *bchDecoder) syndrome() {
+	order := len(b.log) - 1
+	p := len(b.parity) * 8
+
+	for j := 1; j <= 2*b.t; j++ {
+		if j%2 == 0 {
+			b.syndromes[j] = b.mul(b.syndromes[j/2], b.syndromes[j/2])
+			continue
+		}
+		s := 0
+		for i := 0; i < p; i++ {
+			if getBit(b.parity, i) {
+				s ^= b.exp[((p-1-i)*j)%order]
+			}
+		}
+		b.syndromes[j] = s
+	}
+}
+
+// berlekampMassey finds the error locator and returns its degree.
+func (b *bchDecoder) berlekampMassey() int {
+	c := b.locator
+	p := b.previous
+	for i := range c {
+		c[i] = 0
+		p[i] = 0
+	}
+	c[0] = 1
+	p[0] = 1
+
+	length := 0
+	shift := 1
+	last := 1
+	for n := 0; n < 2*b.t; n++ {
+		d := b.syndromes[n+1]
+		for i := 1; i <= length; i++ {
+			d ^= b.mul(c[i], b.syndromes[n+1-i])
+		}
+
+		if d == 0 {
+			shift++
+			continue
+		}
+
+		factor := b.div(d, last)
+		if 2*length <= n {
+			copy(b.scratch, c)
+			for i := 0; i+shift < len(c); i++ {
+				c[i+shift] ^= b.mul(factor, p[i])
+			}
+			length = n + 1 - length
+			copy(p, b.scratch)
+			last = d
+			shift = 1
+		} else {
+			for i := 0; i+shift < len(c); i++ {
+				c[i+shift] ^= b.mul(factor, p[i])
+			}
+			shift++
+		}
+	}
+
+	return length
+}
@@ -0,0 +1,20 @@
+package dvb2s
+
+// Frames are packed MSB first: bit i of a frame is the bit 0x80>>(i%8) of the
+// byte i/8. All frame sizes of the standard are multiples of 8 bits.
+
+func getBit(data []byte, i int) bool {
+	return data[i>>3]&(0x80>>uint(i&0x07)) > 0
+}
+
+func setBit(data []byte, i int, value bool) {
+	if value {
+		data[i>>3] |= 0x80 >> uint(i&0x07)
+	} else {
+		data[i>>3] &^= 0x80 >> uint(i&0x07)
+	}
+}
+
+func flipBit(data []byte, i int) {
+	data[i>>3] ^= 0x80 >> uint(i&0x07)
+}
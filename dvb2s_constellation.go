@@ -0,0 +1,139 @@
+package dvb2s
+
+import (
+	"math"
+	"math/cmplx"
+	"sync"
+)
+
+// constellation holds the points of a MODCOD indexed by the bits of a symbol,
+// the first bit is the MSB of the index. Points have the unit mean energy.
+type constellation struct {
+	bitsPerSymbol int
+	points        []complex128
+	rings         []int // ring of every point, from the inner one
+	ringCount     int
+}
+
+var (
+	constellationCache      = map[int]*constellation{}
+	constellationCacheMutex sync.Mutex
+)
+
+// newConstellation returns the constellation of the MODCOD, figures 9 to 12,
+// the radii ratios of APSK depend on the code rate.
+func newConstellation(modcod int) *constellation {
+	constellationCacheMutex.Lock()
+	defer constellationCacheMutex.Unlock()
+
+	if c, ok := constellationCache[modcod]; ok {
+		return c
+	}
+
+	if modcod <= 0 || modcod >= len(modcodBitsPerSymbol) {
+		panic("unsupported MODCOD\n")
+	}
+
+	var c constellation
+
+	c.bitsPerSymbol = modcodBitsPerSymbol[modcod]
+	rate := modcodCodeRate[modcod]
+
+	switch c.bitsPerSymbol {
+	case 2:
+		c.points = []complex128{
+			complex(map1Pi4, map1Pi4),
+			complex(map1Pi4, -map1Pi4),
+			complex(-map1Pi4, map1Pi4),
+			complex(-map1Pi4, -map1Pi4),
+		}
+		c.rings = make([]int, len(c.points))
+	case 3:
+		c.rings = make([]int, len(psk8Phases))
+		c.points = constellationApsk([]float64{1.0}, c.rings, psk8Phases)
+	case 4:
+		c.rings = apsk16Rings
+		c.points = constellationApsk(append([]float64{1.0}, apsk16Gamma[rate]...), apsk16Rings, apsk16Phases)
+		c.normalize()
+	case 5:
+		c.rings = apsk32Rings
+		c.points = constellationApsk(append([]float64{1.0}, apsk32Gamma[rate]...), apsk32Rings, apsk32Phases)
+		c.normalize()
+	default:
+		panic("unsupported MODCOD\n")
+	}
+
+	for _, ring := range c.rings {
+		if ring >= c.ringCount {
+			c.ringCount = ring + 1
+		}
+	}
+
+	constellationCache[modcod] = &c
+
+	return &c
+}
+
+// constellationApsk places the point i on the ring rings[i] of radii at the
+// phase phases[i] in units of pi.
+func constellationApsk(radii []float64, rings []int, phases []float64) []complex128 {
+	points := make([]complex128, len(phases))
+	for i, phase := range phases {
+		if len(radii) <= rings[i] {
+			panic("unsupported MODCOD\n")
+		}
+		points[i] = cmplx.Rect(radii[rings[i]], phase*math.Pi)
+	}
+	return points
+}
+
+func (c *constellation) normalize() {
+	energy := 0.0
+	for _, value := range c.points {
+		energy += real(value)*real(value) + imag(value)*imag(value)
+	}
+	scale := complex(math.Sqrt(float64(len(c.points))/energy), 0.0)
+	for i := range c.points {
+		c.points[i] *= scale
+	}
+}
+
+// demap writes bitsPerSymbol LLRs per symbol, log(P(0)/P(1)), for the complex
+// noise variance. The exact LLR sums the likelihoods of all points, max-log
+// keeps the nearest point of each bit value only.
+func (c *constellation) demap(symbols []complex128, noiseVariance float64, exact bool, llr []float64) {
+	var metrics [32]float64
+	m := c.bitsPerSymbol
+
+	for j, symbol := range symbols {
+		for i, point := range c.points {
+			e := symbol - point
+			metrics[i] = -(real(e)*real(e) + imag(e)*imag(e)) / noiseVariance
+		}
+
+		for k := 0; k < m; k++ {
+			mask := 1 << uint(m-1-k)
+			zero := math.Inf(-1)
+			one := math.Inf(-1)
+			for i, metric := range metrics[:len(c.points)] {
+				if i&mask > 0 {
+					one = demapCombine(one, metric, exact)
+				} else {
+					zero = demapCombine(zero, metric, exact)
+				}
+			}
+			llr[j*m+k] = zero - one
+		}
+	}
+}
+
+// demapCombine is log(exp(a)+exp(b)) or its max-log approximation.
+func demapCombine(a float64, b float64, exact bool) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	if !exact || math.IsInf(b, -1) {
+		return a
+	}
+	return a + math.Log1p(math.Exp(b-a))
+}
@@ -0,0 +1,134 @@
+package dvb2s
+
+import (
+	"fmt"
+	"io"
+)
+
+// DecoderStatus describes the received signal. The PLSCODE and the quality
+// are of the last decoded PLFRAME.
+type DecoderStatus struct {
+	Locked       bool
+	Modcod       int
+	ShortFrames  bool
+	Pilots       bool
+	Quality      Quality
+	Frames       int // PLFRAMEs found
+	DummyFrames  int
+	FrameErrors  int // PLFRAMEs lost by PLSCODE, FEC or BBHEADER
+	BchCorrected int // bits
+	SyncLosses   int
+	Packets      int
+	CrcErrors    int
+}
+
+// ModcodName returns the name of the MODCOD like "QPSK 3/4".
+func (s DecoderStatus) ModcodName() string {
+	return ModcodName(s.Modcod)
+}
+
+// Decoder recovers the transport stream from oversampled IQ samples by the
+// front end, the receiver and the TS deframer.
+type Decoder struct {
+	f        *frontEnd
+	r        *receiver
+	deframer *tsDeframer
+	status   DecoderStatus
+}
+
+// NewDecoder makes the decoder of samples at the integer oversampling, TS
+// packets go to writer.
+func NewDecoder(writer io.Writer, oversampling int) (*Decoder, error) {
+	var d Decoder
+
+	if oversampling < 2 {
+		return nil, fmt.Errorf("oversampling %d is less than 2", oversampling)
+	}
+
+	d.f = newFrontEnd(oversampling)
+	d.r = newReceiver()
+	d.deframer = newTsDeframer(writer)
+
+	return &d, nil
+}
+
+// SetRolloff sets the roll-off of the matched filter, 0.35, 0.25 or 0.20.
+func (d *Decoder) SetRolloff(rolloff float64) error {
+	if _, ok := rolloffMap[rolloff]; !ok {
+		return fmt.Errorf("unsupported roll-off %g", rolloff)
+	}
+	d.f.setRolloff(rolloff)
+	return nil
+}
+
+// SetGoldCode sets the PL scrambling sequence of the gold code n.
+func (d *Decoder) SetGoldCode(n int) error {
+	scrambling, err := plGoldScrambling(n)
+	if err != nil {
+		return err
+	}
+	d.f.plScrambling = scrambling
+	d.r.plScrambling = scrambling
+	return nil
+}
+
+// SetLdpc sets the maximum iterations of the min-sum LDPC decoder and the scale
+// and the offset of its messages. Scale below 1.0 with offset 0.0 is the
+// normalized min-sum, scale 1.0 with a positive offset is the offset one.
+func (d *Decoder) SetLdpc(iterations int, scale float64, offset float64) error {
+	return d.r.setLdpc(iterations, scale, offset)
+}
+
+// Decode takes the next samples and writes packets of PLFRAMEs found.
+func (d *Decoder) Decode(samples []complex128) error {
+	return d.f.process(samples, d.decodeFrame)
+}
+
+// Flush decodes the last PLFRAME and writes the last packet.
+func (d *Decoder) Flush() error {
+	if err := d.f.flush(d.decodeFrame); err != nil {
+		return err
+	}
+	return d.deframer.flush()
+}
+
+func (d *Decoder) Status() DecoderStatus {
+	s := d.status
+	s.Locked = d.f.locked
+	s.Frames = d.f.frames
+	s.SyncLosses = d.f.syncLosses
+	s.Packets = d.deframer.packets
+	s.CrcErrors = d.deframer.crcErrors
+	return s
+}
+
+// decodeFrame counts PLFRAMEs lost, the error of the writer stops decoding.
+func (d *Decoder) decodeFrame(symbols []complex128) error {
+	if _, err := d.r.decodePlFrame(symbols); err != nil {
+		d.status.FrameErrors++
+		return nil
+	}
+
+	h, bbFrame, err := d.r.decodeFec()
+	if err == errPlDummyFrame {
+		d.status.DummyFrames++
+		return nil
+	}
+	if err != nil {
+		d.status.FrameErrors++
+		return nil
+	}
+
+	d.status.Modcod = d.r.modcod
+	d.status.ShortFrames = d.r.isShortFrame()
+	d.status.Pilots = d.r.hasPilots()
+	d.status.Quality = d.r.quality()
+	d.status.BchCorrected += d.r.bchCorrected
+
+	if err := checkTsFrame(h); err != nil {
+		d.status.FrameErrors++
+		return nil
+	}
+
+	return d.deframer.push(h, bbFrame)
+}
@@ -2,9 +2,11 @@ package dvb2s
 
 import "math"
 
-const bchPolyNLength int = 17 // TODO: N - Normal frame, change const name
 const ldpcBlockSize int = 360
 const slotSize int = 90
+const pilotBlockSize int = 36
+const pilotPeriod int = 16 // slots between pilot blocks
+const plFrameMaxSize int = 64800/2 + (64800/2/slotSize-1)/pilotPeriod*pilotBlockSize
 
 var map1Pi4 = 0.5 * math.Sqrt(2.0)
 
@@ -14,6 +16,121 @@ var (
 		"small":  16200,
 	}
 
+	crc8Table = newCrc8Table(0xd5)
+
+	bbScrambleTable = newBbScrambleTable(64800 / 8)
+
+	plScrambleTable = newPlScrambleTable(plFrameMaxSize, 0)
+
+	rolloffMap = map[float64]uint8{
+		0.35: TransmissionRolloffFactor035,
+		0.25: TransmissionRolloffFactor025,
+		0.20: TransmissionRolloffFactor020,
+	}
+
+	modcodName = []string{
+		"DUMMY",
+		"QPSK 1/4", "QPSK 1/3", "QPSK 2/5", "QPSK 1/2", "QPSK 3/5", "QPSK 2/3",
+		"QPSK 3/4", "QPSK 4/5", "QPSK 5/6", "QPSK 8/9", "QPSK 9/10",
+		"8PSK 3/5", "8PSK 2/3", "8PSK 3/4", "8PSK 5/6", "8PSK 8/9", "8PSK 9/10",
+		"16APSK 2/3", "16APSK 3/4", "16APSK 4/5", "16APSK 5/6", "16APSK 8/9", "16APSK 9/10",
+		"32APSK 3/4", "32APSK 4/5", "32APSK 5/6", "32APSK 8/9", "32APSK 9/10",
+	}
+
+	modcodBitsPerSymbol = []int{
+		0,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2,
+		3, 3, 3, 3, 3, 3,
+		4, 4, 4, 4, 4, 4,
+		5, 5, 5, 5, 5,
+	}
+
+	modcodCodeRate = []string{
+		"",
+		"1/4", "1/3", "2/5", "1/2", "3/5", "2/3", "3/4", "4/5", "5/6", "8/9", "9/10",
+		"3/5", "2/3", "3/4", "5/6", "8/9", "9/10",
+		"2/3", "3/4", "4/5", "5/6", "8/9", "9/10",
+		"3/4", "4/5", "5/6", "8/9", "9/10",
+	}
+
+	// bchCodeMap holds the BCH codes of all code rates, but a rate reaches
+	// the encoder and the decoder only with its table in ldpcTableMap. The
+	// t=8 and t=10 codes of 8/9 and 9/10 are tested on their own until then.
+	bchCodeMap = map[string]map[string]bchCode{
+		"normal": {
+			"1/4":  {16008, 16200, 12},
+			"1/3":  {21408, 21600, 12},
+			"2/5":  {25728, 25920, 12},
+			"1/2":  {32208, 32400, 12},
+			"3/5":  {38688, 38880, 12},
+			"2/3":  {43040, 43200, 10},
+			"3/4":  {48408, 48600, 12},
+			"4/5":  {51648, 51840, 12},
+			"5/6":  {53840, 54000, 10},
+			"8/9":  {57472, 57600, 8},
+			"9/10": {58192, 58320, 8},
+		},
+		"small": {
+			"1/4": {3072, 3240, 12},
+			"1/3": {5232, 5400, 12},
+			"2/5": {6312, 6480, 12},
+			"1/2": {7032, 7200, 12},
+			"3/5": {9552, 9720, 12},
+			"2/3": {10632, 10800, 12},
+			"3/4": {11712, 11880, 12},
+			"4/5": {12432, 12600, 12},
+			"5/6": {13152, 13320, 12},
+			"8/9": {14232, 14400, 12},
+		},
+	}
+
+	// ldpcTableMap holds the tables of annexes B and C transcribed so far,
+	// only normal 3/4. SupportedModcods follows it.
+	ldpcTableMap = map[string]map[string][][]int16{
+		"normal": {
+			"3/4": ldpcTable3_4,
+		},
+	}
+
+	psk8Phases = []float64{1.0 / 4.0, 0.0, 1.0, 5.0 / 4.0, 1.0 / 2.0, 7.0 / 4.0, 3.0 / 4.0, 3.0 / 2.0}
+
+	apsk16Rings  = []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0}
+	apsk16Phases = []float64{
+		1.0 / 4.0, -1.0 / 4.0, 3.0 / 4.0, -3.0 / 4.0,
+		1.0 / 12.0, -1.0 / 12.0, 11.0 / 12.0, -11.0 / 12.0,
+		5.0 / 12.0, -5.0 / 12.0, 7.0 / 12.0, -7.0 / 12.0,
+		1.0 / 4.0, -1.0 / 4.0, 3.0 / 4.0, -3.0 / 4.0,
+	}
+
+	apsk16Gamma = map[string][]float64{ // table 9
+		"2/3":  {3.15},
+		"3/4":  {2.85},
+		"4/5":  {2.75},
+		"5/6":  {2.70},
+		"8/9":  {2.60},
+		"9/10": {2.57},
+	}
+
+	apsk32Rings  = []int{1, 1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 2, 2, 1, 0, 1, 0, 1, 0, 1, 0, 2, 2, 2, 2, 2, 2, 2, 2}
+	apsk32Phases = []float64{
+		1.0 / 4.0, 5.0 / 12.0, -1.0 / 4.0, -5.0 / 12.0,
+		3.0 / 4.0, 7.0 / 12.0, -3.0 / 4.0, -7.0 / 12.0,
+		1.0 / 8.0, 3.0 / 8.0, -1.0 / 4.0, -1.0 / 2.0,
+		3.0 / 4.0, 1.0 / 2.0, -7.0 / 8.0, -5.0 / 8.0,
+		1.0 / 12.0, 1.0 / 4.0, -1.0 / 12.0, -1.0 / 4.0,
+		11.0 / 12.0, 3.0 / 4.0, -11.0 / 12.0, -3.0 / 4.0,
+		0.0, 1.0 / 4.0, -1.0 / 8.0, -3.0 / 8.0,
+		7.0 / 8.0, 5.0 / 8.0, 1.0, -3.0 / 4.0,
+	}
+
+	apsk32Gamma = map[string][]float64{ // table 10
+		"3/4":  {2.84, 5.27},
+		"4/5":  {2.72, 4.87},
+		"5/6":  {2.64, 4.64},
+		"8/9":  {2.54, 4.33},
+		"9/10": {2.53, 4.30},
+	}
+
 	plHeaderSof = []bool{
 		false, true, true, false, false, false, true, true,
 		false, true, false, false, true, false, true, true,
@@ -42,7 +159,7 @@ var (
 		0xffffffff,
 	}
 
-	bchPolyN = [][bchPolyNLength]bool{ //TODO: replace [bchPolyNLength] to []
+	bchPolyN = [][]bool{
 		{true, false, true, true, false, true, false, false, false, false, false, false, false, false, false, false, true},
 		{true, true, false, false, true, true, true, false, true, false, false, false, false, false, false, false, true},
 		{true, false, true, true, true, true, false, true, true, true, true, true, false, false, false, false, true},
@@ -57,6 +174,21 @@ var (
 		{true, true, false, false, false, true, true, true, false, true, false, true, true, false, false, false, true},
 	}
 
+	bchPolyS = [][]bool{
+		{true, true, false, true, false, true, false, false, false, false, false, false, false, false, true},
+		{true, false, false, false, false, false, true, false, true, false, false, true, false, false, true},
+		{true, true, true, false, false, false, true, false, false, true, true, false, false, false, true},
+		{true, false, false, false, true, false, false, true, true, false, true, false, true, false, true},
+		{true, false, true, false, true, false, true, false, true, true, false, true, false, true, true},
+		{true, false, false, true, false, false, false, true, true, true, false, false, false, true, true},
+		{true, false, true, false, false, true, true, true, false, false, true, true, false, true, true},
+		{true, false, false, false, false, true, false, false, true, true, true, true, false, false, true},
+		{true, true, true, true, false, false, false, false, false, true, true, false, false, false, true},
+		{true, false, false, true, false, false, true, false, false, true, false, true, true, false, true},
+		{true, false, false, false, true, false, false, false, false, false, false, true, true, false, true},
+		{true, true, true, true, false, true, true, true, true, false, true, false, false, true, true},
+	}
+
 	ldpcTable3_4 = [][]int16{
 		{0, 6385, 7901, 14611, 13389, 11200, 3252, 5243, 2504, 2722, 821, 7374},
 		{1, 11359, 2698, 357, 13824, 12772, 7244, 6752, 15310, 852, 2001, 11417},
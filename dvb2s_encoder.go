@@ -0,0 +1,272 @@
+package dvb2s
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ivantaran/dvb2sgo/channel"
+)
+
+const encoderQueueSize int = 4
+
+// Encoder turns a transport stream into baseband samples in a pipeline of
+// goroutines: mode adaptation, FEC encoding by several workers, mapping with
+// PL framing and shaping. Frames leave the pipeline in the input order.
+type Encoder struct {
+	d       *dvb2s
+	workers int
+	pool    *framePool
+	meta    *SigmfMeta
+	samples int // passed to output
+}
+
+func NewEncoder(fecFrameType string, oversampling int, workers int) *Encoder {
+	return newEncoder(newDvb2s(fecFrameType, oversampling, false), workers)
+}
+
+// NewEncoderModcod makes the encoder of the MODCOD of the frame type, with
+// pilots or without, at the integer oversampling.
+func NewEncoderModcod(modcod int, fecFrameType string, pilots bool, oversampling int, workers int) (*Encoder, error) {
+	if err := checkModcod(modcod, fecFrameType); err != nil {
+		return nil, err
+	}
+	if oversampling < 2 {
+		return nil, fmt.Errorf("oversampling %d is less than 2", oversampling)
+	}
+
+	return newEncoder(newDvb2sModcod(modcod, fecFrameType, pilots, oversampling, false), workers), nil
+}
+
+func newEncoder(d *dvb2s, workers int) *Encoder {
+	var e Encoder
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	e.d = d
+	e.workers = workers
+	e.pool = newFramePool(e.d, workers+2*encoderQueueSize)
+
+	return &e
+}
+
+// SetRolloff sets the roll-off of shaping, 0.35, 0.25 or 0.20.
+func (e *Encoder) SetRolloff(rolloff float64) error {
+	if _, ok := rolloffMap[rolloff]; !ok {
+		return fmt.Errorf("unsupported roll-off %g", rolloff)
+	}
+	e.d.setRolloff(rolloff)
+	return nil
+}
+
+// SetGoldCode sets the PL scrambling sequence of the gold code n.
+func (e *Encoder) SetGoldCode(n int) error {
+	scrambling, err := plGoldScrambling(n)
+	if err != nil {
+		return err
+	}
+	e.d.plScrambling = scrambling
+	return nil
+}
+
+// SetPredistortion sets the static predistortion of the MODCOD, radius factors
+// and phases in radians of rings from the inner one. Nil slices turn
+// predistortion off.
+func (e *Encoder) SetPredistortion(radius []float64, phase []float64) error {
+	c := e.d.constellation
+	if radius != nil && (len(radius) != c.ringCount || len(phase) != c.ringCount) {
+		return fmt.Errorf("%s has %d rings", modcodName[e.d.modcod], c.ringCount)
+	}
+	e.d.setPredistortion(radius, phase)
+	return nil
+}
+
+// TrainPredistortion trains the dynamic predistortion of the MODCOD, on top of
+// the static one, for the amplifier driven by the shaped signal at the input
+// backoff in dB.
+func (e *Encoder) TrainPredistortion(amplifier channel.Amplifier, backoff float64, iterations int) {
+	power := 0.0
+	for _, value := range e.d.firFilter.coefficients {
+		power += value * value
+	}
+
+	hpa := channel.NewHpa(amplifier)
+	hpa.SetInputBackoff(backoff, power/float64(e.d.oversampling))
+	e.d.trainPredistortion(hpa, iterations, 1)
+}
+
+// SetSigmfMeta makes the encoder annotate every PLFRAME passed to output in
+// m, nil stops it. Samples are counted from the first output of the encoder.
+func (e *Encoder) SetSigmfMeta(m *SigmfMeta) {
+	e.meta = m
+}
+
+// stage returns a copy of the encoder state working on its own frames, with
+// own FEC registers.
+func (d *dvb2s) stage() *dvb2s {
+	s := *d
+	s.frame = nil
+	s.bchEncoder = d.bchEncoder.clone()
+	s.ldpcEncoder = d.ldpcEncoder.clone()
+	return &s
+}
+
+// Encode reads the transport stream until EOF and passes samples of every
+// PLFRAME to output. Samples are valid during the call of output only. The
+// first error of a stage, of output or of ctx stops the pipeline. Encode is not
+// safe for concurrent use.
+func (e *Encoder) Encode(ctx context.Context, input io.Reader, output func(samples []complex128) error) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var err error
+
+	fail := func(stageErr error) {
+		errOnce.Do(func() { err = stageErr })
+		cancel()
+	}
+
+	send := func(c chan<- *frame, f *frame) bool {
+		select {
+		case c <- f:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	adapted := make(chan *frame, encoderQueueSize)
+	encoded := make(chan *frame, encoderQueueSize)
+	mapped := make(chan *frame, encoderQueueSize)
+	shaped := make(chan *frame, encoderQueueSize)
+
+	e.d.SetInputStream(input)
+	e.pool.reset()
+
+	fecStages := make([]*dvb2s, e.workers)
+	for i := range fecStages {
+		fecStages[i] = e.d.stage()
+	}
+	mapStage := e.d.stage()
+	shapeStage := e.d.stage()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(adapted)
+
+		d := e.d
+		for i := 0; ; i++ {
+			select {
+			case d.frame = <-e.pool.free:
+			case <-ctx.Done():
+				return
+			}
+			d.index = i
+			if err := d.LoadInputStream(); err != nil {
+				if err != io.EOF {
+					fail(err)
+				}
+				return
+			}
+			if !send(adapted, d.frame) {
+				return
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for _, fecStage := range fecStages {
+		workers.Add(1)
+		go func(d *dvb2s) {
+			defer workers.Done()
+
+			for d.frame = range adapted {
+				d.bbFrameScramble()
+				d.bchEncode()
+				d.ldpcEncode()
+				if !send(encoded, d.frame) {
+					return
+				}
+			}
+		}(fecStage)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		workers.Wait()
+		close(encoded)
+	}()
+
+	wg.Add(1)
+	go func(d *dvb2s) {
+		defer wg.Done()
+		defer close(mapped)
+
+		pending := make([]*frame, len(e.pool.frames))
+		next := 0
+		for f := range encoded {
+			pending[f.index%len(pending)] = f
+			for {
+				f := pending[next%len(pending)]
+				if f == nil || f.index != next {
+					break
+				}
+				pending[next%len(pending)] = nil
+				next++
+
+				d.frame = f
+				d.bitInterleave()
+				d.mapIntoConstellation()
+				d.plHeaderEncode()
+				d.plScramble()
+				if !send(mapped, d.frame) {
+					return
+				}
+			}
+		}
+	}(mapStage)
+
+	wg.Add(1)
+	go func(d *dvb2s) {
+		defer wg.Done()
+		defer close(shaped)
+
+		for d.frame = range mapped {
+			d.outInterpolateBbShape()
+			if !send(shaped, d.frame) {
+				return
+			}
+		}
+	}(shapeStage)
+
+	for f := range shaped {
+		if outputErr := output(f.outFrame); outputErr != nil {
+			fail(outputErr)
+			break
+		}
+		if e.meta != nil {
+			e.d.annotateFrame(e.meta, e.samples, len(f.outFrame))
+		}
+		e.samples += len(f.outFrame)
+		e.pool.put(f)
+	}
+
+	cancel()
+	for range shaped {
+	}
+	wg.Wait()
+
+	if err == nil {
+		err = parent.Err()
+	}
+
+	return err
+}
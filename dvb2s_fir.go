@@ -1,11 +1,60 @@
 package dvb2s
 
+import "math"
+
+const firRrcSpan int = 32 // symbols of the designed filter
+
 type fir struct {
 	taps         []complex128
 	coefficients []float64
 	offset       int
 }
 
+// firRrcTable returns the root raised cosine filter of the oversampling and
+// the roll-off, filters missing in tables are designed.
+func firRrcTable(oversampling int, rolloff float64) []float64 {
+	if rolloff == 0.35 {
+		switch oversampling {
+		case 2:
+			// return firRrc2x035Table
+			return firRrc2x035BigTable
+		case 4:
+			return firRrc4x035Table
+		}
+	}
+	if oversampling < 2 {
+		panic("unknown oversampling\n")
+	}
+	return firRrcDesign(oversampling, rolloff, firRrcSpan)
+}
+
+// firRrcDesign samples the impulse response of the root raised cosine filter
+// over span symbols, the peak goes at the middle as in tables. The gain at the
+// zero frequency is 1.
+func firRrcDesign(oversampling int, rolloff float64, span int) []float64 {
+	coefficients := make([]float64, span*oversampling)
+	sum := 0.0
+	for n := range coefficients {
+		t := float64(n-len(coefficients)/2) / float64(oversampling)
+		switch {
+		case t == 0.0:
+			coefficients[n] = 1.0 - rolloff + 4.0*rolloff/math.Pi
+		case math.Abs(math.Abs(t)-1.0/(4.0*rolloff)) < 1e-9:
+			a := math.Pi / (4.0 * rolloff)
+			coefficients[n] = rolloff / math.Sqrt2 * ((1.0+2.0/math.Pi)*math.Sin(a) + (1.0-2.0/math.Pi)*math.Cos(a))
+		default:
+			x := 4.0 * rolloff * t
+			coefficients[n] = (math.Sin(math.Pi*t*(1.0-rolloff)) + x*math.Cos(math.Pi*t*(1.0+rolloff))) /
+				(math.Pi * t * (1.0 - x*x))
+		}
+		sum += coefficients[n]
+	}
+	for n := range coefficients {
+		coefficients[n] /= sum
+	}
+	return coefficients
+}
+
 func newFir(coefficients []float64) *fir {
 	var f fir
 
@@ -34,3 +83,8 @@ func (f *fir) fir(value complex128) complex128 {
 
 	return r
 }
+
+// delay returns the group delay in samples of the symmetric filter.
+func (f *fir) delay() int {
+	return len(f.coefficients) / 2
+}
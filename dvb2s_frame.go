@@ -0,0 +1,78 @@
+package dvb2s
+
+// frame holds the buffers of one frame on its way from BBFRAME to samples, so
+// stages of the encoder can work on different frames at the same time.
+type frame struct {
+	index     int
+	fecFrame  []byte
+	bitFrame  []byte
+	bbFrame   []byte
+	bchBlock  []byte
+	bchFec    []byte
+	ldpcFec   []byte
+	plSymbols []complex128
+	plHeader  []complex128
+	plFrame   []complex128
+	labels    []uint8 // bits of every data symbol
+	outFrame  []complex128
+}
+
+// newFrame takes sizes of BBFRAME, BCH block and FECFRAME in bits and size of
+// PLFRAME payload in symbols.
+func newFrame(bbFrameSize int, bchBlockSize int, fecFrameSize int, plFrameSize int, oversampling int) *frame {
+	var f frame
+
+	f.fecFrame = make([]byte, fecFrameSize/8)
+	f.bitFrame = make([]byte, fecFrameSize/8)
+	f.bbFrame = f.fecFrame[:bbFrameSize/8]
+	f.bchBlock = f.fecFrame[:bchBlockSize/8]
+	f.bchFec = f.fecFrame[bbFrameSize/8 : bchBlockSize/8]
+	f.ldpcFec = f.fecFrame[bchBlockSize/8:]
+	f.plSymbols = make([]complex128, slotSize+plFrameSize)
+	f.plHeader = f.plSymbols[:slotSize]
+	f.plFrame = f.plSymbols[slotSize:]
+	f.labels = make([]uint8, plFrameSize)
+	f.outFrame = make([]complex128, len(f.plSymbols)*oversampling)
+
+	return &f
+}
+
+// newFrame makes a frame of the same sizes as the current one.
+func (d *dvb2s) newFrame() *frame {
+	return newFrame(len(d.bbFrame)*8, len(d.bchBlock)*8, len(d.fecFrame)*8, len(d.plFrame), d.oversampling)
+}
+
+// framePool holds a fixed set of frames. The first stage of the pipeline waits
+// for a free frame, the frame is put back after its samples are passed out, so
+// the steady state of encoding makes no allocations.
+type framePool struct {
+	frames []*frame
+	free   chan *frame
+}
+
+func newFramePool(d *dvb2s, size int) *framePool {
+	var p framePool
+
+	p.frames = make([]*frame, size)
+	p.free = make(chan *frame, size)
+	for i := range p.frames {
+		p.frames[i] = d.newFrame()
+	}
+	p.reset()
+
+	return &p
+}
+
+// reset puts back frames lost by a stopped pipeline.
+func (p *framePool) reset() {
+	for len(p.free) > 0 {
+		<-p.free
+	}
+	for _, f := range p.frames {
+		p.free <- f
+	}
+}
+
+func (p *framePool) put(f *frame) {
+	p.free <- f
+}
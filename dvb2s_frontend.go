@@ -0,0 +1,492 @@
+package dvb2s
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// frontEndSyncThreshold is the least normalized differential correlation of
+// SOF and PLSCODE taken as a PLHEADER.
+const frontEndSyncThreshold float64 = 0.45
+
+// Gains of the timing loop, of the decision directed phase loop and of the
+// average of the frequency.
+const (
+	frontEndTimingGain     float64 = 0.005
+	frontEndTimingIntegral float64 = 1e-5
+	frontEndPhaseGain      float64 = 0.02
+	frontEndPhaseIntegral  float64 = 1e-4
+	frontEndFrequencyGain  float64 = 0.5
+	frontEndAcquisition    float64 = 4.0 // timing gains scale before lock
+	frontEndTurns          int     = 2   // of the phase tried between PLHEADER and pilots
+	frontEndPowerAverage   float64 = 1e-3
+)
+
+// frontEnd recovers symbol synchronized PLFRAMEs from oversampled IQ samples
+// like outFrame. Samples are matched filtered and taken at symbol instants by
+// the Gardner timing loop. PLHEADERs are found by differential correlation,
+// which does not care of the frequency offset. PLHEADERs estimate the
+// frequency until the lock, then the frequency of previous frames is taken.
+// The phase follows pilots, or decisions on frames without pilots.
+type frontEnd struct {
+	oversampling int
+	matched      *fir
+	plScrambling []uint8
+	frequency    float64 // average, cycles per symbol
+	filtered     []complex128
+	strobe       float64 // position of the next symbol in filtered
+	timingRate   float64
+	power        float64
+	previous     complex128
+	symbols      []complex128 // not yet framed
+	locked       bool
+	modcod       int
+	fecFrameType int
+	offset       float64 // frequency of the frame, cycles per symbol
+	amplitude    float64
+	header       [slotSize]complex128
+	frame        []complex128
+	phases       []float64
+	syncMetric   float64
+	plsMetric    float64
+	frames       int
+	syncLosses   int
+}
+
+func newFrontEnd(oversampling int) *frontEnd {
+	var f frontEnd
+
+	f.oversampling = oversampling
+	f.matched = newFir(firRrcTable(oversampling, 0.35))
+	f.plScrambling = plScrambleTable
+	f.strobe = float64(oversampling)
+	f.frame = make([]complex128, 0, slotSize+plFrameMaxSize)
+	f.phases = make([]float64, 0, slotSize+plFrameMaxSize)
+
+	return &f
+}
+
+// setRolloff sets the matched filter of the roll-off.
+func (f *frontEnd) setRolloff(rolloff float64) {
+	f.matched = newFir(firRrcTable(f.oversampling, rolloff))
+}
+
+// process takes the next samples and passes every recovered PLFRAME to
+// output. Symbols of PLFRAME start with PLHEADER, they are derotated and
+// scaled to the constellation and valid during the call of output only.
+func (f *frontEnd) process(samples []complex128, output func(symbols []complex128) error) error {
+	f.recoverTiming(samples)
+	return f.synchronize(output, false)
+}
+
+// flush runs out the matched filter and passes the last PLFRAME, which has no
+// PLHEADER after it.
+func (f *frontEnd) flush(output func(symbols []complex128) error) error {
+	f.recoverTiming(make([]complex128, len(f.matched.coefficients)))
+	return f.synchronize(output, true)
+}
+
+func (f *frontEnd) recoverTiming(samples []complex128) {
+	for _, value := range samples {
+		f.filtered = append(f.filtered, f.matched.fir(value))
+	}
+
+	if f.power == 0.0 {
+		f.power = meanPower(f.filtered)
+		if f.power == 0.0 {
+			f.filtered = f.filtered[:0]
+			return
+		}
+	}
+
+	half := float64(f.oversampling) / 2.0
+	for f.strobe+2.0 < float64(len(f.filtered)) {
+		y := interpolateCubic(f.filtered, f.strobe)
+		middle := interpolateCubic(f.filtered, f.strobe-half)
+		f.symbols = append(f.symbols, y)
+
+		e := real(cmplx.Conj(middle)*(y-f.previous)) / f.power
+		e = math.Max(-1.0, math.Min(1.0, e))
+		f.previous = y
+		f.power += frontEndPowerAverage * (real(y)*real(y) + imag(y)*imag(y) - f.power)
+
+		gain := 1.0
+		if !f.locked {
+			gain = frontEndAcquisition
+		}
+		f.timingRate += gain * frontEndTimingIntegral * e
+		f.strobe += 2.0*half - gain*frontEndTimingGain*2.0*half*e - f.timingRate
+	}
+
+	if n := int(f.strobe-half) - 2; n > 0 {
+		f.filtered = f.filtered[:copy(f.filtered, f.filtered[n:])]
+		f.strobe -= float64(n)
+	}
+}
+
+// interpolateCubic is the Lagrange interpolation of x at the position t,
+// x[floor(t)-1] and x[floor(t)+2] are taken.
+func interpolateCubic(x []complex128, t float64) complex128 {
+	i := int(t)
+	mu := t - float64(i)
+	c0 := -mu * (mu - 1.0) * (mu - 2.0) / 6.0
+	c1 := (mu + 1.0) * (mu - 1.0) * (mu - 2.0) / 2.0
+	c2 := -(mu + 1.0) * mu * (mu - 2.0) / 2.0
+	c3 := (mu + 1.0) * mu * (mu - 1.0) / 6.0
+	return complex(c0, 0.0)*x[i-1] + complex(c1, 0.0)*x[i] + complex(c2, 0.0)*x[i+1] + complex(c3, 0.0)*x[i+2]
+}
+
+func meanPower(x []complex128) float64 {
+	power := 0.0
+	for _, value := range x {
+		power += real(value)*real(value) + imag(value)*imag(value)
+	}
+	if len(x) == 0 {
+		return 0.0
+	}
+	return power / float64(len(x))
+}
+
+// synchronize frames symbols. A PLFRAME is passed when PLHEADER after it is
+// found, or when the frame itself was found after the previous one, so the
+// frame sync locks on two PLHEADERs in a row.
+func (f *frontEnd) synchronize(output func(symbols []complex128) error, last bool) error {
+	for {
+		if !f.locked {
+			position, found := f.search()
+			f.consume(position)
+			if !found {
+				return nil
+			}
+		}
+		if len(f.symbols) < slotSize {
+			return nil
+		}
+
+		size, err := f.decodeHeader()
+		if err != nil {
+			f.lose()
+			f.consume(1)
+			continue
+		}
+		if len(f.symbols) < size+slotSize && !(last && len(f.symbols) >= size) {
+			return nil
+		}
+
+		next := false
+		if len(f.symbols) >= size+slotSize {
+			next = syncCorrelate(f.symbols[size:]) >= frontEndSyncThreshold
+		}
+		if !next && !f.locked && !last {
+			f.consume(1)
+			continue
+		}
+
+		f.track(size, next)
+		f.frames++
+		if err := output(f.frame); err != nil {
+			return err
+		}
+
+		f.consume(size)
+		if next {
+			f.locked = true
+		} else if last {
+			f.locked = false
+			return nil
+		} else {
+			f.lose()
+		}
+	}
+}
+
+func (f *frontEnd) lose() {
+	if f.locked {
+		f.syncLosses++
+	}
+	f.locked = false
+}
+
+func (f *frontEnd) consume(n int) {
+	f.symbols = f.symbols[:copy(f.symbols, f.symbols[n:])]
+}
+
+// search returns the position of the first PLHEADER candidate, the peak of
+// the correlation over the threshold. Without a candidate it returns the
+// position to go on from with more symbols.
+func (f *frontEnd) search() (int, bool) {
+	if len(f.symbols) <= slotSize {
+		return 0, false
+	}
+
+	metric := syncCorrelate(f.symbols)
+	for i := 0; i+slotSize < len(f.symbols); i++ {
+		next := syncCorrelate(f.symbols[i+1:])
+		if metric >= frontEndSyncThreshold && metric >= next {
+			f.syncMetric = metric
+			return i, true
+		}
+		metric = next
+	}
+
+	return len(f.symbols) - slotSize, false
+}
+
+// syncCorrelate is the differential correlation of SOF and of pairs of the
+// PLSCODE, which are x and x^b0, with symbols. The magnitude is normalized to
+// the magnitude of the products.
+func syncCorrelate(symbols []complex128) float64 {
+	var sof, pls complex128
+	norm := 0.0
+
+	previous := symbols[0] * cmplx.Conj(plHeaderSymbol(plHeaderSof[0], 0))
+	for n := 1; n < len(plHeaderSof); n++ {
+		value := symbols[n] * cmplx.Conj(plHeaderSymbol(plHeaderSof[n], n))
+		product := value * cmplx.Conj(previous)
+		sof += product
+		norm += cmplx.Abs(product)
+		previous = value
+	}
+
+	for i := 0; i < slotSize-len(plHeaderSof); i += 2 {
+		n := i + len(plHeaderSof)
+		first := symbols[n] * cmplx.Conj(plHeaderSymbol(plHeaderScrambleTable[i], n))
+		second := symbols[n+1] * cmplx.Conj(plHeaderSymbol(plHeaderScrambleTable[i+1], n+1))
+		product := second * cmplx.Conj(first)
+		pls += product
+		norm += cmplx.Abs(product)
+	}
+
+	if norm == 0.0 {
+		return 0.0
+	}
+	return (cmplx.Abs(sof) + cmplx.Abs(pls)) / norm
+}
+
+// decodeHeader decodes the PLSCODE and returns the size of the PLFRAME. The
+// average frequency is taken when locked, else the frequency is estimated on
+// SOF with lags 1 and 13 and refined on the whole PLHEADER with lag 45.
+func (f *frontEnd) decodeHeader() (int, error) {
+	var z [slotSize]complex128
+	symbols := f.symbols[:slotSize]
+
+	for n := range plHeaderSof {
+		z[n] = symbols[n] * cmplx.Conj(plHeaderSymbol(plHeaderSof[n], n))
+	}
+	offset := f.frequency
+	if !f.locked {
+		offset = lagFrequency(z[:len(plHeaderSof)], 1, 0.0)
+		offset = lagFrequency(z[:len(plHeaderSof)], len(plHeaderSof)/2, offset)
+	}
+
+	c := complex(0.0, 0.0)
+	for n := range plHeaderSof {
+		c += z[n] * cmplx.Rect(1.0, -2.0*math.Pi*offset*float64(n))
+	}
+	if c == 0 {
+		return 0, errPlSofNotFound
+	}
+	for n, value := range symbols {
+		f.header[n] = value * cmplx.Rect(1.0, -2.0*math.Pi*offset*float64(n))
+	}
+	plsCode, plsMetric := plHeaderDecode(f.header[:], cmplx.Conj(c)/complex(cmplx.Abs(c), 0.0))
+	f.plsMetric = plsMetric
+	f.modcod = plsCode >> 2
+	f.fecFrameType = plsCode & 0x03
+
+	slots, pilotBlocks, err := plFrameSlots(f.modcod, f.fecFrameType)
+	if err != nil {
+		return 0, err
+	}
+
+	plHeaderMap(f.modcod, f.fecFrameType, f.header[:])
+	for n, value := range symbols {
+		z[n] = value * cmplx.Conj(f.header[n])
+	}
+	f.offset = offset
+	if !f.locked {
+		f.offset = lagFrequency(z[:], slotSize/2, offset)
+	}
+
+	return slotSize + slots*slotSize + pilotBlocks*pilotBlockSize, nil
+}
+
+// lagFrequency refines the frequency offset in cycles per symbol of z, which
+// is free of the modulation, by the correlation of z with itself delayed by
+// lag. The offset must be known within 1/(2*lag).
+func lagFrequency(z []complex128, lag int, offset float64) float64 {
+	c := complex(0.0, 0.0)
+	for n := lag; n < len(z); n++ {
+		c += z[n] * cmplx.Conj(z[n-lag]) * cmplx.Rect(1.0, -2.0*math.Pi*offset*float64(lag))
+	}
+	return offset + cmplx.Phase(c)/(2.0*math.Pi*float64(lag))
+}
+
+// track derotates the PLFRAME of size symbols into frame. The phase is
+// interpolated between PLHEADER, pilot blocks and SOF of the next PLHEADER, or
+// it is tracked by decisions on frames without pilots. The frequency of the
+// frame goes to the average.
+func (f *frontEnd) track(size int, next bool) {
+	_, pilotBlocks, _ := plFrameSlots(f.modcod, f.fecFrameType)
+
+	f.frame = f.frame[:size]
+	for n, value := range f.symbols[:size] {
+		f.frame[n] = value * cmplx.Rect(1.0, -2.0*math.Pi*f.offset*float64(n))
+	}
+
+	c := complex(0.0, 0.0)
+	for n, value := range f.frame[:slotSize] {
+		c += value * cmplx.Conj(f.header[n])
+	}
+	f.amplitude = cmplx.Abs(c) / float64(slotSize)
+
+	anchors := []frontEndAnchor{{center: float64(slotSize-1) / 2.0, phase: cmplx.Phase(c)}}
+	pilot := complex(map1Pi4, map1Pi4)
+	for k := 0; k < pilotBlocks; k++ {
+		start := (k+1)*pilotPeriod*slotSize + k*pilotBlockSize
+		c = 0
+		for i := start; i < start+pilotBlockSize; i++ {
+			c += f.frame[slotSize+i] * cmplx.Conj(plRotate(pilot, f.plScrambling[i]))
+		}
+		anchors = append(anchors, frontEndAnchor{center: float64(slotSize+start) + float64(pilotBlockSize-1)/2.0, phase: cmplx.Phase(c)})
+	}
+
+	if pilotBlocks > 0 {
+		if next {
+			c = 0
+			for n := range plHeaderSof {
+				value := f.symbols[size+n] * cmplx.Rect(1.0, -2.0*math.Pi*f.offset*float64(size+n))
+				c += value * cmplx.Conj(plHeaderSymbol(plHeaderSof[n], n))
+			}
+			anchors = append(anchors, frontEndAnchor{center: float64(size) + float64(len(plHeaderSof)-1)/2.0, phase: cmplx.Phase(c)})
+		}
+		f.interpolatePhase(anchors, f.resolveSlope(anchors[0], anchors[1]))
+	} else {
+		f.trackPhase(anchors[0])
+	}
+
+	scale := complex(1.0/f.amplitude, 0.0)
+	for n, phase := range f.phases {
+		f.frame[n] *= cmplx.Rect(1.0, -phase) * scale
+	}
+
+	if f.locked && pilotBlocks == 0 {
+		f.frequency += frontEndFrequencyGain * (f.offset - f.frequency)
+	} else if f.locked || next {
+		f.frequency = f.offset
+	}
+}
+
+// frontEndAnchor is the phase measured on known symbols around center.
+type frontEndAnchor struct {
+	center float64
+	phase  float64
+}
+
+// resolveSlope returns the phase slope from PLHEADER to the first pilot block.
+// The frequency of PLHEADER is not good enough to unwrap the phase over 16
+// slots, so turns of the phase are tried by decisions on the data between.
+func (f *frontEnd) resolveSlope(header frontEndAnchor, pilot frontEndAnchor) float64 {
+	points := newConstellation(f.modcod).points
+	scale := complex(1.0/f.amplitude, 0.0)
+	length := pilot.center - header.center
+	difference := math.Remainder(pilot.phase-header.phase, 2.0*math.Pi)
+
+	slope := 0.0
+	best := math.Inf(1)
+	for turns := -frontEndTurns; turns <= frontEndTurns; turns++ {
+		s := (difference + 2.0*math.Pi*float64(turns)) / length
+		metric := 0.0
+		for n := slotSize; n < slotSize+pilotPeriod*slotSize; n++ {
+			value := f.frame[n] * cmplx.Rect(1.0, -header.phase-s*(float64(n)-header.center)) * scale
+			value = plRotate(value, 4-f.plScrambling[n-slotSize])
+			e := value - nearestPoint(points, value)
+			metric += real(e)*real(e) + imag(e)*imag(e)
+		}
+		if metric < best {
+			best = metric
+			slope = s
+		}
+	}
+
+	return slope
+}
+
+// interpolatePhase unwraps phases of anchors one by one starting with slope
+// and interpolates the phase linearly between them, the first and the last
+// segments go on to the ends of the frame. The slope adds to the frequency of
+// the frame.
+func (f *frontEnd) interpolatePhase(anchors []frontEndAnchor, slope float64) {
+	for k := 1; k < len(anchors); k++ {
+		predicted := anchors[k-1].phase + slope*(anchors[k].center-anchors[k-1].center)
+		anchors[k].phase -= 2.0 * math.Pi * math.Round((anchors[k].phase-predicted)/(2.0*math.Pi))
+		slope = (anchors[k].phase - anchors[k-1].phase) / (anchors[k].center - anchors[k-1].center)
+	}
+
+	first := anchors[0]
+	last := anchors[len(anchors)-1]
+	f.offset += (last.phase - first.phase) / (last.center - first.center) / (2.0 * math.Pi)
+
+	f.phases = f.phases[:len(f.frame)]
+	k := 1
+	for n := range f.phases {
+		for k < len(anchors)-1 && float64(n) > anchors[k].center {
+			k++
+		}
+		a, b := anchors[k-1], anchors[k]
+		f.phases[n] = a.phase + (b.phase-a.phase)*(float64(n)-a.center)/(b.center-a.center)
+	}
+}
+
+// trackPhase runs the second order loop on decisions of data symbols, DUMMY
+// PLFRAMEs carry unmodulated symbols. The frequency of the loop adds to the
+// frequency of the frame.
+func (f *frontEnd) trackPhase(header frontEndAnchor) {
+	var points []complex128
+	if f.modcod != plDummyModcod {
+		points = newConstellation(f.modcod).points
+	}
+	pilot := complex(map1Pi4, map1Pi4)
+	scale := complex(1.0/f.amplitude, 0.0)
+
+	f.phases = f.phases[:len(f.frame)]
+	for n := 0; n < slotSize; n++ {
+		f.phases[n] = header.phase
+	}
+
+	phase := header.phase
+	frequency := 0.0
+	for n := slotSize; n < len(f.frame); n++ {
+		f.phases[n] = phase
+		value := f.frame[n] * cmplx.Rect(1.0, -phase) * scale
+		reference := plRotate(pilot, f.plScrambling[n-slotSize])
+		if points != nil {
+			value = plRotate(value, 4-f.plScrambling[n-slotSize])
+			reference = nearestPoint(points, value)
+		}
+
+		e := imag(value*cmplx.Conj(reference)) / (real(reference)*real(reference) + imag(reference)*imag(reference))
+		frequency += frontEndPhaseIntegral * e
+		phase += frequency + frontEndPhaseGain*e
+	}
+
+	f.offset += frequency / (2.0 * math.Pi)
+}
+
+func nearestPoint(points []complex128, value complex128) complex128 {
+	return points[nearestLabel(points, value)]
+}
+
+// nearestLabel returns the index of the point nearest to value.
+func nearestLabel(points []complex128, value complex128) int {
+	best := math.Inf(1)
+	nearest := 0
+	for i, point := range points {
+		e := value - point
+		if distance := real(e)*real(e) + imag(e)*imag(e); distance < best {
+			best = distance
+			nearest = i
+		}
+	}
+	return nearest
+}
@@ -0,0 +1,375 @@
+package dvb2s
+
+import (
+	"math"
+	"sync"
+)
+
+const ldpcBlockWords int = (ldpcBlockSize + 63) / 64
+
+// Parity bit p of the code is kept at the position k of the row r of the
+// accumulator, p = r + k*q. An address x of the table hits the row x%q with
+// the block of 360 information bits rotated by x/q, so every address is one
+// xor of a block shifted into a row of 720 bits, folded at the end.
+const ldpcRowWords int = (2*ldpcBlockSize+63)/64 + 1
+
+type ldpcAddress struct {
+	row   int
+	shift int
+}
+
+// ldpcCode holds the addresses of the table of a code split to rows and
+// rotations of the accumulator.
+type ldpcCode struct {
+	table      [][]int16
+	q          int
+	addresses  [][]ldpcAddress
+	checksOnce sync.Once
+	checkStart []int32
+	checkBits  []int32
+}
+
+type ldpcCodeKey struct {
+	frameType string
+	rate      string
+}
+
+var (
+	ldpcCodeCache      = map[ldpcCodeKey]*ldpcCode{}
+	ldpcCodeCacheMutex sync.Mutex
+)
+
+// newLdpcCode returns the code of the frame type and the code rate, addresses
+// are computed once per code.
+func newLdpcCode(frameType string, rate string) *ldpcCode {
+	ldpcCodeCacheMutex.Lock()
+	defer ldpcCodeCacheMutex.Unlock()
+
+	key := ldpcCodeKey{frameType, rate}
+	if c, ok := ldpcCodeCache[key]; ok {
+		return c
+	}
+
+	table, ok := ldpcTableMap[frameType][rate]
+	if !ok {
+		panic("unsupported LDPC code\n")
+	}
+
+	var c ldpcCode
+
+	c.table = table
+	c.q = (fecFramesizeMap[frameType] - bchCodeMap[frameType][rate].nbch) / ldpcBlockSize
+	c.addresses = make([][]ldpcAddress, len(table))
+	for j, row := range table {
+		c.addresses[j] = make([]ldpcAddress, len(row))
+		for i, value := range row {
+			c.addresses[j][i] = ldpcAddress{int(value) % c.q, int(value) / c.q}
+		}
+	}
+
+	ldpcCodeCache[key] = &c
+
+	return &c
+}
+
+// checks builds the parity check matrix once per code, the bits of the check r
+// are checkBits[checkStart[r]:checkStart[r+1]]. The check r holds the parity
+// bits r and r-1 besides the information bits hitting it.
+func (c *ldpcCode) checks() ([]int32, []int32) {
+	c.checksOnce.Do(func() {
+		m := c.q * ldpcBlockSize
+		k := len(c.table) * ldpcBlockSize
+
+		degrees := make([]int32, m+1)
+		for _, row := range c.table {
+			for i := 0; i < ldpcBlockSize; i++ {
+				for _, value := range row {
+					degrees[(int(value)+i*c.q)%m]++
+				}
+			}
+		}
+
+		c.checkStart = make([]int32, m+1)
+		for r := 0; r < m; r++ {
+			degrees[r] += 2
+			if r == 0 {
+				degrees[r]--
+			}
+			c.checkStart[r+1] = c.checkStart[r] + degrees[r]
+		}
+
+		c.checkBits = make([]int32, c.checkStart[m])
+		fill := append([]int32(nil), c.checkStart[:m]...)
+		for j, row := range c.table {
+			for i := 0; i < ldpcBlockSize; i++ {
+				for _, value := range row {
+					r := (int(value) + i*c.q) % m
+					c.checkBits[fill[r]] = int32(j*ldpcBlockSize + i)
+					fill[r]++
+				}
+			}
+		}
+		for r := 0; r < m; r++ {
+			if r > 0 {
+				c.checkBits[fill[r]] = int32(k + r - 1)
+				fill[r]++
+			}
+			c.checkBits[fill[r]] = int32(k + r)
+		}
+	})
+
+	return c.checkStart, c.checkBits
+}
+
+type ldpcEncoder struct {
+	code        *ldpcCode
+	block       [ldpcBlockWords]uint64
+	accumulator []uint64
+}
+
+func newLdpcEncoder(frameType string, rate string) *ldpcEncoder {
+	var e ldpcEncoder
+
+	e.code = newLdpcCode(frameType, rate)
+	e.accumulator = make([]uint64, e.code.q*ldpcRowWords)
+
+	return &e
+}
+
+// clone returns an encoder of the same code with its own accumulator.
+func (e *ldpcEncoder) clone() *ldpcEncoder {
+	var c ldpcEncoder
+
+	c.code = e.code
+	c.accumulator = make([]uint64, len(e.accumulator))
+
+	return &c
+}
+
+// encode writes parity of the information bits, len(info) is a multiple of
+// 45 bytes and len(parity) is q*45 bytes.
+func (e *ldpcEncoder) encode(info []byte, parity []byte) {
+	q := e.code.q
+
+	for i := range e.accumulator {
+		e.accumulator[i] = 0
+	}
+
+	for j, addresses := range e.code.addresses {
+		e.loadBlock(info[j*ldpcBlockSize/8 : (j+1)*ldpcBlockSize/8])
+
+		for _, address := range addresses {
+			row := e.accumulator[address.row*ldpcRowWords:]
+			w := address.shift / 64
+			s := uint(address.shift % 64)
+			if s == 0 {
+				for n, value := range e.block {
+					row[w+n] ^= value
+				}
+			} else {
+				for n, value := range e.block {
+					row[w+n] ^= value >> s
+					row[w+n+1] ^= value << (64 - s)
+				}
+			}
+		}
+	}
+
+	for i := range parity {
+		parity[i] = 0x00
+	}
+
+	for r := 0; r < q; r++ {
+		row := e.accumulator[r*ldpcRowWords:]
+		for k := 0; k < ldpcBlockSize; k++ {
+			b := (row[k/64] >> uint(63-k%64)) ^ (row[(k+ldpcBlockSize)/64] >> uint(63-(k+ldpcBlockSize)%64))
+			if b&0x01 > 0 {
+				flipBit(parity, r+k*q)
+			}
+		}
+	}
+
+	ldpcAccumulate(parity)
+}
+
+func (e *ldpcEncoder) loadBlock(data []byte) {
+	for n := range e.block {
+		var value uint64
+		for i := 0; i < 8; i++ {
+			value <<= 8
+			if n*8+i < len(data) {
+				value |= uint64(data[n*8+i])
+			}
+		}
+		e.block[n] = value
+	}
+}
+
+// encodeSerial is the bit by bit reference of encode.
+func (e *ldpcEncoder) encodeSerial(info []byte, parity []byte) {
+	for i := range parity {
+		parity[i] = 0x00
+	}
+
+	parityLength := len(parity) * 8
+	for j, row := range e.code.table {
+		for i := 0; i < ldpcBlockSize; i++ {
+			if !getBit(info, i+j*ldpcBlockSize) {
+				continue
+			}
+			for _, value := range row {
+				addr := (int(value) + i*e.code.q) % parityLength
+				flipBit(parity, addr)
+			}
+		}
+	}
+
+	ldpcAccumulate(parity)
+}
+
+// ldpcAccumulate replaces parity bits with the running xor, p[i] ^= p[i-1].
+func ldpcAccumulate(parity []byte) {
+	var carry byte
+	for i, b := range parity {
+		b ^= b >> 1
+		b ^= b >> 2
+		b ^= b >> 4
+		b ^= -carry
+		parity[i] = b
+		carry = b & 0x01
+	}
+}
+
+const ldpcDecoderIterations int = 50
+const ldpcDecoderScale float64 = 0.75
+
+// ldpcDecoder is the layered min-sum decoder, checks are updated one by one
+// and every update refreshes the totals of its bits at once. The magnitude of
+// a message is the least input magnitude scaled and offset, scale 1.0 with a
+// positive offset is the offset min-sum, offset 0.0 is the normalized one.
+// Codes are the ones of ldpcTableMap, so far normal 3/4.
+type ldpcDecoder struct {
+	code          *ldpcCode
+	maxIterations int
+	scale         float64
+	offset        float64
+	totals        []float64
+	messages      []float64
+	inputs        []float64
+	iterations    int
+}
+
+func newLdpcDecoder(frameType string, rate string) *ldpcDecoder {
+	var d ldpcDecoder
+
+	d.code = newLdpcCode(frameType, rate)
+	d.maxIterations = ldpcDecoderIterations
+	d.scale = ldpcDecoderScale
+
+	checkStart, checkBits := d.code.checks()
+	maxDegree := 0
+	for r := 1; r < len(checkStart); r++ {
+		if degree := int(checkStart[r] - checkStart[r-1]); degree > maxDegree {
+			maxDegree = degree
+		}
+	}
+
+	d.totals = make([]float64, (len(d.code.table)+d.code.q)*ldpcBlockSize)
+	d.messages = make([]float64, len(checkBits))
+	d.inputs = make([]float64, maxDegree)
+
+	return &d
+}
+
+// clone returns a decoder of the same code with its own messages.
+func (d *ldpcDecoder) clone() *ldpcDecoder {
+	c := *d
+	c.totals = make([]float64, len(d.totals))
+	c.messages = make([]float64, len(d.messages))
+	c.inputs = make([]float64, len(d.inputs))
+	return &c
+}
+
+// decode takes LLRs of the codeword, log(P(0)/P(1)), and writes the hard
+// decision of the codeword packed into bytes. It stops on the zero syndrome
+// and reports if the codeword is valid.
+func (d *ldpcDecoder) decode(llr []float64, codeword []byte) bool {
+	checkStart, checkBits := d.code.checks()
+
+	copy(d.totals, llr)
+	for i := range d.messages {
+		d.messages[i] = 0.0
+	}
+
+	valid := d.syndrome()
+	d.iterations = 0
+	for !valid && d.iterations < d.maxIterations {
+		for r := 0; r+1 < len(checkStart); r++ {
+			d.updateCheck(checkBits[checkStart[r]:checkStart[r+1]], d.messages[checkStart[r]:checkStart[r+1]])
+		}
+		d.iterations++
+		valid = d.syndrome()
+	}
+
+	for i, value := range d.totals {
+		setBit(codeword, i, value < 0.0)
+	}
+
+	return valid
+}
+
+func (d *ldpcDecoder) updateCheck(bits []int32, messages []float64) {
+	inputs := d.inputs[:len(bits)]
+	min1 := math.Inf(1)
+	min2 := math.Inf(1)
+	minIndex := 0
+	negative := false
+
+	for i, bit := range bits {
+		value := d.totals[bit] - messages[i]
+		inputs[i] = value
+		if value < 0.0 {
+			negative = !negative
+			value = -value
+		}
+		if value < min1 {
+			min2 = min1
+			min1 = value
+			minIndex = i
+		} else if value < min2 {
+			min2 = value
+		}
+	}
+
+	min1 = math.Max(d.scale*min1-d.offset, 0.0)
+	min2 = math.Max(d.scale*min2-d.offset, 0.0)
+
+	for i, bit := range bits {
+		message := min1
+		if i == minIndex {
+			message = min2
+		}
+		if negative != (inputs[i] < 0.0) {
+			message = -message
+		}
+		messages[i] = message
+		d.totals[bit] = inputs[i] + message
+	}
+}
+
+// syndrome checks the hard decision of the totals against all checks.
+func (d *ldpcDecoder) syndrome() bool {
+	checkStart, checkBits := d.code.checks()
+
+	for r := 0; r+1 < len(checkStart); r++ {
+		parity := false
+		for _, bit := range checkBits[checkStart[r]:checkStart[r+1]] {
+			parity = parity != (d.totals[bit] < 0.0)
+		}
+		if parity {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,215 @@
+package dvb2s
+
+import (
+	"math/cmplx"
+	"math/rand"
+
+	"github.com/ivantaran/dvb2sgo/channel"
+)
+
+const predistortionStep float64 = 0.5
+const predistortionSymbolsPerContext int = 16
+
+// predistortion moves points of the constellation against the compression of
+// the amplifier. The static part scales and turns every ring, the dynamic part
+// replaces a symbol by the entry of the table indexed by the previous, the
+// current and the next symbol, so it also corrects the interference of the
+// shaped neighbours going through the amplifier.
+type predistortion struct {
+	radius []float64 // per ring
+	phase  []float64
+	table  []complex128
+}
+
+// setPredistortion sets the static correction of rings of the MODCOD, nil
+// slices take the constellation back.
+func (d *dvb2s) setPredistortion(radius []float64, phase []float64) {
+	c := d.constellation
+
+	if radius == nil {
+		d.predistortion = nil
+		d.points = c.points
+		return
+	}
+	if len(radius) != c.ringCount || len(phase) != c.ringCount {
+		panic("wrong number of rings of predistortion\n")
+	}
+
+	d.predistortion = &predistortion{radius: radius, phase: phase}
+	d.points = make([]complex128, len(c.points))
+	for i, value := range c.points {
+		ring := c.rings[i]
+		d.points[i] = value * cmplx.Rect(radius[ring], phase[ring])
+	}
+}
+
+// newStaticPredistortion finds ring corrections of the constellation driving
+// the amplifier with drive times the amplitude of a symbol. The outer ring
+// stays, inner rings are driven to keep the ratios of radii at the output and
+// turned to the phase of the outer ring.
+func newStaticPredistortion(c *constellation, amplifier channel.Amplifier, drive float64) ([]float64, []float64) {
+	radii := make([]float64, c.ringCount)
+	for i, value := range c.points {
+		radii[c.rings[i]] = cmplx.Abs(value)
+	}
+
+	outer := radii[len(radii)-1]
+	outerAmplitude, outerPhase := amplifier.Response(drive * outer)
+
+	radius := make([]float64, len(radii))
+	phase := make([]float64, len(radii))
+	for ring, r := range radii {
+		target := outerAmplitude * r / outer
+
+		low := 0.0
+		high := amplifier.Saturation() / drive
+		for i := 0; i < 60; i++ {
+			x := (low + high) / 2.0
+			if amplitude, _ := amplifier.Response(drive * x); amplitude < target {
+				low = x
+			} else {
+				high = x
+			}
+		}
+
+		x := (low + high) / 2.0
+		_, p := amplifier.Response(drive * x)
+		radius[ring] = x / r
+		phase[ring] = outerPhase - p
+	}
+
+	return radius, phase
+}
+
+// apply replaces data symbols of plFrame by entries of the table.
+func (p *predistortion) apply(d *dvb2s) {
+	m := uint(d.constellation.bitsPerSymbol)
+	labels := d.labels[:len(d.bitFrame)*8/int(m)]
+
+	for j := range labels {
+		d.plFrame[d.plFramePosition(j)] = p.table[predistortionContext(labels, j, m)]
+	}
+}
+
+// predistortionContext indexes the table by the symbol j with neighbours,
+// symbols out of the frame are zeros.
+func predistortionContext(labels []uint8, j int, m uint) int {
+	previous, next := 0, 0
+	if j > 0 {
+		previous = int(labels[j-1])
+	}
+	if j+1 < len(labels) {
+		next = int(labels[j+1])
+	}
+	return previous<<(2*m) | int(labels[j])<<m | next
+}
+
+// trainPredistortion builds the dynamic table of the encoder by iterations of
+// random symbols through the shaping filter, the amplifier and the matched
+// filter. Every entry moves by the mean error of its symbols against the
+// constellation scaled by the gain of the link.
+func (d *dvb2s) trainPredistortion(hpa *channel.Hpa, iterations int, seed int64) {
+	if d.predistortion == nil {
+		d.predistortion = &predistortion{}
+	}
+
+	m := uint(d.constellation.bitsPerSymbol)
+	size := len(d.points)
+	contexts := size * size * size
+
+	table := make([]complex128, contexts)
+	for i := range table {
+		table[i] = d.points[(i>>m)&(size-1)]
+	}
+
+	random := rand.New(rand.NewSource(seed))
+	labels := make([]uint8, predistortionSymbolsPerContext*contexts)
+	symbols := make([]complex128, len(labels))
+	received := make([]complex128, len(labels))
+	errors := make([]complex128, contexts)
+	counts := make([]int, contexts)
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		for i := range labels {
+			labels[i] = uint8(random.Intn(size))
+		}
+		for j := range labels {
+			symbols[j] = table[predistortionContext(labels, j, m)]
+		}
+
+		d.predistortionLink(hpa, symbols, received)
+		gain := d.predistortionGain(labels, received)
+
+		for i := range errors {
+			errors[i] = 0.0
+			counts[i] = 0
+		}
+		for j, value := range received {
+			i := predistortionContext(labels, j, m)
+			errors[i] += d.constellation.points[labels[j]] - value/gain
+			counts[i]++
+		}
+		for i, e := range errors {
+			if counts[i] > 0 {
+				table[i] += complex(predistortionStep/float64(counts[i]), 0.0) * e
+			}
+		}
+	}
+
+	d.predistortion.table = table
+}
+
+// predistortionLink passes symbols through the shaping filter, the amplifier
+// and the matched filter and takes received symbols at the peaks.
+func (d *dvb2s) predistortionLink(hpa *channel.Hpa, symbols []complex128, received []complex128) {
+	shaping := newFir(d.firFilter.coefficients)
+	matched := newFir(d.firFilter.coefficients)
+	delay := 2 * shaping.delay()
+	samples := make([]complex128, 1)
+
+	j := 0
+	for n := 0; j < len(received); n++ {
+		value := complex(0.0, 0.0)
+		if n%d.oversampling == 0 && n/d.oversampling < len(symbols) {
+			value = symbols[n/d.oversampling]
+		}
+		samples[0] = shaping.fir(value)
+		hpa.Process(samples)
+		value = matched.fir(samples[0])
+		if n >= delay && (n-delay)%d.oversampling == 0 {
+			received[j] = value
+			j++
+		}
+	}
+}
+
+// predistortionGain is the least squares gain of received symbols against the
+// constellation.
+func (d *dvb2s) predistortionGain(labels []uint8, received []complex128) complex128 {
+	gain := complex(0.0, 0.0)
+	power := 0.0
+	for j, value := range received {
+		ideal := d.constellation.points[labels[j]]
+		gain += value * cmplx.Conj(ideal)
+		power += real(ideal)*real(ideal) + imag(ideal)*imag(ideal)
+	}
+	return gain / complex(power, 0.0)
+}
+
+// predistortionMer returns MER in dB of symbols of the encoder through the
+// link, it checks the training.
+func (d *dvb2s) predistortionMer(hpa *channel.Hpa, labels []uint8) float64 {
+	m := uint(d.constellation.bitsPerSymbol)
+	symbols := make([]complex128, len(labels))
+	received := make([]complex128, len(labels))
+	for j, label := range labels {
+		symbols[j] = d.points[label]
+		if d.predistortion != nil && d.predistortion.table != nil {
+			symbols[j] = d.predistortion.table[predistortionContext(labels, j, m)]
+		}
+	}
+
+	d.predistortionLink(hpa, symbols, received)
+
+	return measureQuality(d.constellation, received, labels).Mer
+}
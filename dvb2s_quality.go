@@ -0,0 +1,133 @@
+package dvb2s
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Quality measures received symbols against ideal points of the constellation
+// as ETSI TR 101 290 does. Symbols are scaled and turned by the least squares
+// gain of the link first, so the measures hold the noise and the distortion
+// left around the constellation.
+type Quality struct {
+	Symbols int
+	Mer     float64 // dB
+	EvmRms  float64 // percent of the outer ring
+	EvmPeak float64 // percent of the outer ring
+	Snr     float64 // dB, M2M4 estimate, blind to the distortion
+	Rings   []RingQuality
+}
+
+// RingQuality is the gain of a ring against the gain of the link, the
+// compression of APSK rings by the amplifier shows here.
+type RingQuality struct {
+	Radius    float64
+	Symbols   int
+	Amplitude float64 // dB
+	Phase     float64 // degrees
+}
+
+// measureQuality compares data symbols with points of labels, nil labels are
+// taken by decisions on symbols scaled to the unit mean energy, the phase must
+// be recovered then.
+func measureQuality(c *constellation, symbols []complex128, labels []uint8) Quality {
+	var q Quality
+
+	q.Symbols = len(symbols)
+	if len(symbols) == 0 {
+		return q
+	}
+
+	m2 := 0.0
+	m4 := 0.0
+	for _, value := range symbols {
+		power := real(value)*real(value) + imag(value)*imag(value)
+		m2 += power
+		m4 += power * power
+	}
+	m2 /= float64(len(symbols))
+	m4 /= float64(len(symbols))
+
+	if labels == nil {
+		labels = make([]uint8, len(symbols))
+		scale := complex(1.0/math.Sqrt(m2), 0.0)
+		for j, value := range symbols {
+			labels[j] = uint8(nearestLabel(c.points, value*scale))
+		}
+	}
+
+	ringGains := make([]complex128, c.ringCount)
+	ringPowers := make([]float64, c.ringCount)
+	q.Rings = make([]RingQuality, c.ringCount)
+	ideal4 := 0.0
+	for j, value := range symbols {
+		ideal := c.points[labels[j]]
+		ring := c.rings[labels[j]]
+		idealPower := real(ideal)*real(ideal) + imag(ideal)*imag(ideal)
+		ringGains[ring] += value * cmplx.Conj(ideal)
+		ringPowers[ring] += idealPower
+		ideal4 += idealPower * idealPower
+		q.Rings[ring].Symbols++
+	}
+
+	gain := complex(0.0, 0.0)
+	power := 0.0
+	for ring := range ringGains {
+		gain += ringGains[ring]
+		power += ringPowers[ring]
+	}
+	gain /= complex(power, 0.0)
+
+	outer := 0.0
+	for i, point := range c.points {
+		radius := cmplx.Abs(point)
+		outer = math.Max(outer, radius)
+		q.Rings[c.rings[i]].Radius = radius
+	}
+
+	for ring := range q.Rings {
+		if q.Rings[ring].Symbols == 0 {
+			continue
+		}
+		relative := ringGains[ring] / complex(ringPowers[ring], 0.0) / gain
+		q.Rings[ring].Amplitude = 20.0 * math.Log10(cmplx.Abs(relative))
+		q.Rings[ring].Phase = cmplx.Phase(relative) * 180.0 / math.Pi
+	}
+
+	noise := 0.0
+	peak := 0.0
+	for j, value := range symbols {
+		e := value/gain - c.points[labels[j]]
+		power := real(e)*real(e) + imag(e)*imag(e)
+		noise += power
+		peak = math.Max(peak, power)
+	}
+
+	q.Mer = 10.0 * math.Log10(power/noise)
+	q.EvmRms = 100.0 * math.Sqrt(noise/float64(len(symbols))) / outer
+	q.EvmPeak = 100.0 * math.Sqrt(peak) / outer
+	q.Snr = qualitySnr(ideal4*float64(len(symbols))/(power*power), m2, m4)
+
+	return q
+}
+
+// qualitySnr is the M2M4 estimate of SNR in dB by the second and the fourth
+// moments of symbols. The moments of a signal S in complex AWGN N are
+// M2 = S+N and M4 = ka*S^2+4*S*N+2*N^2 with the kurtosis ka of the points
+// sent. The kurtosis of the whole constellation misses that of a frame by the
+// spread of labels, which spoils the estimate beyond some 25 dB for 32APSK.
+func qualitySnr(ka float64, m2 float64, m4 float64) float64 {
+	signal := math.Sqrt(math.Max(2.0*m2*m2-m4, 0.0) / (2.0 - ka))
+	if signal >= m2 {
+		return math.Inf(1)
+	}
+	return 10.0 * math.Log10(signal/(m2-signal))
+}
+
+// quality measures data symbols of the last decoded PLFRAME by decisions.
+func (r *receiver) quality() Quality {
+	if r.modcod == plDummyModcod || r.modcod >= len(modcodBitsPerSymbol) {
+		return Quality{}
+	}
+	return measureQuality(newConstellation(r.modcod), r.plFrame, nil)
+}
@@ -0,0 +1,372 @@
+package dvb2s
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+)
+
+// plSofThreshold is the least normalized magnitude of the SOF correlation
+// accepted as the start of a PLFRAME.
+const plSofThreshold float64 = 0.6
+
+const plDummyModcod int = 0
+const plDummySlots int = 36
+
+var (
+	errPlSofNotFound = errors.New("no SOF at the start of PLFRAME")
+	errPlDummyFrame  = errors.New("DUMMY PLFRAME")
+)
+
+// plNoiseVarianceFloor keeps LLRs finite on noise free symbols.
+const plNoiseVarianceFloor float64 = 1e-6
+
+// receiver reverses the physical layer framing of symbol synchronized
+// PLFRAMEs. Symbols are derotated and scaled by SOF, the PLSCODE is decoded
+// and the payload is descrambled with pilots stripped off. decodeFec turns the
+// payload back into BBFRAME.
+type receiver struct {
+	modcod         int
+	fecFrameType   int
+	phase          complex128 // unit phasor of SOF
+	amplitude      float64
+	sofMetric      float64
+	plsMetric      float64
+	noiseVariance  float64 // estimated on PLHEADER
+	plHeader       [slotSize]complex128
+	plFrame        []complex128 // descrambled data symbols
+	pilots         []complex128 // descrambled pilot symbols
+	plScrambling   []uint8
+	exactDemap     bool
+	ldpcIterations int
+	ldpcScale      float64
+	ldpcOffset     float64
+	decoders       map[int]*receiverDecoder
+	llr            []float64
+	bitLlr         []float64
+	fecFrame       []byte
+	ldpcValid      bool
+	bchCorrected   int
+}
+
+// receiverDecoder holds the FEC decoders of a MODCOD and a frame size.
+type receiverDecoder struct {
+	constellation *constellation
+	code          bchCode
+	ldpcDecoder   *ldpcDecoder
+	bchDecoder    *bchDecoder
+}
+
+func newReceiver() *receiver {
+	var r receiver
+
+	r.plFrame = make([]complex128, 0, plFrameMaxSize)
+	r.pilots = make([]complex128, 0, plFrameMaxSize-64800/2)
+	r.plScrambling = plScrambleTable
+	r.ldpcIterations = ldpcDecoderIterations
+	r.ldpcScale = ldpcDecoderScale
+	r.decoders = map[int]*receiverDecoder{}
+	r.llr = make([]float64, 64800)
+	r.bitLlr = make([]float64, 64800)
+	r.fecFrame = make([]byte, 64800/8)
+
+	return &r
+}
+
+// setLdpc sets the maximum iterations of the LDPC decoder and the scale and the
+// offset of its min-sum messages, for the decoders made and to be made.
+func (r *receiver) setLdpc(iterations int, scale float64, offset float64) error {
+	if iterations < 1 {
+		return fmt.Errorf("LDPC iterations %d are less than 1", iterations)
+	}
+	if !(scale > 0.0 && scale <= 1.0) {
+		return fmt.Errorf("LDPC scale %g is out of (0, 1]", scale)
+	}
+	if !(offset >= 0.0) {
+		return fmt.Errorf("LDPC offset %g is negative", offset)
+	}
+
+	r.ldpcIterations = iterations
+	r.ldpcScale = scale
+	r.ldpcOffset = offset
+	for _, decoder := range r.decoders {
+		decoder.ldpcDecoder.maxIterations = iterations
+		decoder.ldpcDecoder.scale = scale
+		decoder.ldpcDecoder.offset = offset
+	}
+	return nil
+}
+
+func (r *receiver) isShortFrame() bool {
+	return (r.fecFrameType & 0x02) > 0
+}
+
+func (r *receiver) hasPilots() bool {
+	return (r.fecFrameType & 0x01) > 0
+}
+
+// decodePlFrame decodes the PLFRAME starting at symbols[0] and returns the
+// number of symbols it takes.
+func (r *receiver) decodePlFrame(symbols []complex128) (int, error) {
+	if len(symbols) < slotSize {
+		return 0, io.ErrShortBuffer
+	}
+
+	c, metric := plSofCorrelate(symbols[:len(plHeaderSof)])
+	r.sofMetric = metric
+	if metric < plSofThreshold {
+		return 0, errPlSofNotFound
+	}
+	r.phase = c / complex(cmplx.Abs(c), 0)
+	r.amplitude = cmplx.Abs(c) / float64(len(plHeaderSof))
+	derotate := cmplx.Conj(r.phase) / complex(r.amplitude, 0)
+
+	plsCode, plsMetric := plHeaderDecode(symbols, derotate)
+	r.plsMetric = plsMetric
+	r.modcod = plsCode >> 2
+	r.fecFrameType = plsCode & 0x03
+
+	plHeaderMap(r.modcod, r.fecFrameType, r.plHeader[:])
+	r.noiseVariance = 0.0
+	for i, value := range r.plHeader {
+		e := symbols[i]*derotate - value
+		r.noiseVariance += real(e)*real(e) + imag(e)*imag(e)
+	}
+	r.noiseVariance = math.Max(r.noiseVariance/float64(slotSize), plNoiseVarianceFloor)
+
+	slots, pilotBlocks, err := plFrameSlots(r.modcod, r.fecFrameType)
+	if err != nil {
+		return 0, err
+	}
+	size := slotSize + slots*slotSize + pilotBlocks*pilotBlockSize
+	if len(symbols) < size {
+		return 0, io.ErrShortBuffer
+	}
+
+	r.plFrame = r.plFrame[:0]
+	r.pilots = r.pilots[:0]
+	for i, value := range symbols[slotSize:size] {
+		value = plRotate(value*derotate, 4-r.plScrambling[i])
+		block := i / (pilotPeriod*slotSize + pilotBlockSize)
+		if r.hasPilots() && i%(pilotPeriod*slotSize+pilotBlockSize) >= pilotPeriod*slotSize && block < pilotBlocks {
+			r.pilots = append(r.pilots, value)
+		} else {
+			r.plFrame = append(r.plFrame, value)
+		}
+	}
+
+	return size, nil
+}
+
+// plHeaderDecode descrambles the PLSCODE of PLHEADER symbols turned and scaled
+// by derotate and returns the decoded PLSCODE with its metric.
+func plHeaderDecode(symbols []complex128, derotate complex128) (int, float64) {
+	var soft [slotSize - 26]float64
+	for i := range soft {
+		n := i + len(plHeaderSof)
+		value := symbols[n] * derotate * cmplx.Conj(plHeaderSymbol(false, n))
+		soft[i] = real(value)
+		if plHeaderScrambleTable[i] {
+			soft[i] = -soft[i]
+		}
+	}
+
+	return plsDecode(soft[:])
+}
+
+// plFrameSlots returns the number of data slots and of pilot blocks of the
+// PLSCODE.
+func plFrameSlots(modcod int, fecFrameType int) (int, int, error) {
+	if modcod == plDummyModcod {
+		return plDummySlots, 0, nil
+	}
+	if modcod >= len(modcodBitsPerSymbol) {
+		return 0, 0, fmt.Errorf("unsupported MODCOD %d", modcod)
+	}
+
+	frameType := "normal"
+	if fecFrameType&0x02 > 0 {
+		frameType = "small"
+	}
+	slots := fecFramesizeMap[frameType] / modcodBitsPerSymbol[modcod] / slotSize
+
+	pilotBlocks := 0
+	if fecFrameType&0x01 > 0 {
+		pilotBlocks = (slots - 1) / pilotPeriod
+	}
+
+	return slots, pilotBlocks, nil
+}
+
+// plSofCorrelate correlates symbols with SOF, the magnitude is normalized to
+// the mean magnitude of the symbols.
+func plSofCorrelate(symbols []complex128) (complex128, float64) {
+	var c complex128
+	var norm float64
+
+	for i, value := range symbols {
+		c += value * cmplx.Conj(plHeaderSymbol(plHeaderSof[i], i))
+		norm += cmplx.Abs(value)
+	}
+	if norm == 0.0 {
+		return 0, 0.0
+	}
+
+	return c, cmplx.Abs(c) / norm
+}
+
+// plsDecode is the maximum likelihood decoder of the PLSCODE. Soft values are
+// positive for zeros. Pairs of the code are x and x^b0, so both hypotheses of
+// b0 fold the pairs to the first order Reed-Muller (32,6) code, whose words are
+// x[k] = b1 ^ parity(u & k) with u = b2..b6 reversed, and the fast Hadamard
+// transform correlates all of them at once.
+func plsDecode(soft []float64) (int, float64) {
+	var y [32]float64
+	var norm float64
+	best := -1.0
+	plsCode := 0
+
+	for _, value := range soft {
+		norm += math.Abs(value)
+	}
+
+	for b0 := 0; b0 < 2; b0++ {
+		for k := range y {
+			if b0 > 0 {
+				y[k] = soft[2*k] - soft[2*k+1]
+			} else {
+				y[k] = soft[2*k] + soft[2*k+1]
+			}
+		}
+		fhtTransform(y[:])
+
+		for u, value := range y {
+			if math.Abs(value) <= best {
+				continue
+			}
+			best = math.Abs(value)
+			plsCode = b0
+			if value < 0 {
+				plsCode |= 0x02
+			}
+			for j := 0; j < 5; j++ {
+				if u&(1<<uint(j)) > 0 {
+					plsCode |= 1 << uint(6-j)
+				}
+			}
+		}
+	}
+
+	if norm == 0.0 {
+		return plsCode, 0.0
+	}
+
+	return plsCode, best / norm
+}
+
+// fhtTransform is the in place fast Hadamard transform, len(y) is a power of 2.
+func fhtTransform(y []float64) {
+	for h := 1; h < len(y); h <<= 1 {
+		for i := 0; i < len(y); i += h << 1 {
+			for j := i; j < i+h; j++ {
+				y[j], y[j+h] = y[j]+y[j+h], y[j]-y[j+h]
+			}
+		}
+	}
+}
+
+// decodeFec demaps the payload of the last decoded PLFRAME and decodes LDPC
+// and BCH codes, it returns the descrambled BBFRAME with the parsed header.
+// The BBFRAME is valid until the next call.
+func (r *receiver) decodeFec() (*BbHeader, []byte, error) {
+	bbFrame, err := r.decodeCodeword()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bbFrameDescramble(bbFrame)
+
+	h, err := ParseBbHeader(bbFrame)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return h, bbFrame, nil
+}
+
+// decodeCodeword leaves the decoded FECFRAME in fecFrame and returns its
+// scrambled BBFRAME.
+func (r *receiver) decodeCodeword() ([]byte, error) {
+	if r.modcod == plDummyModcod {
+		return nil, errPlDummyFrame
+	}
+
+	decoder, err := r.decoder()
+	if err != nil {
+		return nil, err
+	}
+	m := decoder.constellation.bitsPerSymbol
+	n := len(r.plFrame) * m
+
+	decoder.constellation.demap(r.plFrame, r.noiseVariance, r.exactDemap, r.bitLlr[:n])
+
+	if m == 2 {
+		copy(r.llr, r.bitLlr[:n])
+	} else {
+		rows := n / m
+		reverse := r.modcod == 12 // 8PSK 3/5
+		for row := 0; row < rows; row++ {
+			for k := 0; k < m; k++ {
+				column := k
+				if reverse {
+					column = m - 1 - k
+				}
+				r.llr[column*rows+row] = r.bitLlr[row*m+k]
+			}
+		}
+	}
+
+	fecFrame := r.fecFrame[:n/8]
+	r.ldpcValid = decoder.ldpcDecoder.decode(r.llr[:n], fecFrame)
+
+	corrected, err := decoder.bchDecoder.decode(fecFrame[:decoder.code.nbch/8])
+	r.bchCorrected = corrected
+	if err != nil {
+		return nil, err
+	}
+
+	return fecFrame[:decoder.code.kbch/8], nil
+}
+
+// decoder returns decoders of the last PLSCODE, they are made once.
+func (r *receiver) decoder() (*receiverDecoder, error) {
+	key := r.modcod<<1 | (r.fecFrameType&0x02)>>1
+	if decoder, ok := r.decoders[key]; ok {
+		return decoder, nil
+	}
+
+	frameType := "normal"
+	if r.isShortFrame() {
+		frameType = "small"
+	}
+	rate := modcodCodeRate[r.modcod]
+	if _, ok := ldpcTableMap[frameType][rate]; !ok {
+		return nil, fmt.Errorf("unsupported PLFRAME: %s %s", modcodName[r.modcod], frameType)
+	}
+
+	var decoder receiverDecoder
+
+	decoder.constellation = newConstellation(r.modcod)
+	decoder.code = bchCodeMap[frameType][rate]
+	decoder.ldpcDecoder = newLdpcDecoder(frameType, rate)
+	decoder.ldpcDecoder.maxIterations = r.ldpcIterations
+	decoder.ldpcDecoder.scale = r.ldpcScale
+	decoder.ldpcDecoder.offset = r.ldpcOffset
+	decoder.bchDecoder = newBchDecoder(decoder.code.t, r.isShortFrame())
+
+	r.decoders[key] = &decoder
+
+	return &decoder, nil
+}
@@ -0,0 +1,105 @@
+package dvb2s
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+const sigmfVersion string = "1.0.0"
+
+var sigmfDatatypeMap = map[int]string{
+	sampleFormatCs8:    "ci8",
+	sampleFormatCs16le: "ci16_le",
+	sampleFormatCs16be: "ci16_be",
+	sampleFormatCf32:   "cf32_le",
+	sampleFormatCf64:   "cf64_le",
+}
+
+type sigmfExtension struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Optional bool   `json:"optional"`
+}
+
+type sigmfGlobal struct {
+	Datatype    string           `json:"core:datatype"`
+	SampleRate  float64          `json:"core:sample_rate"`
+	Version     string           `json:"core:version"`
+	Description string           `json:"core:description,omitempty"`
+	Extensions  []sigmfExtension `json:"core:extensions"`
+	SymbolRate  float64          `json:"dvbs2:symbol_rate"`
+	Rolloff     float64          `json:"dvbs2:rolloff"`
+}
+
+type sigmfCapture struct {
+	SampleStart uint64 `json:"core:sample_start"`
+}
+
+type sigmfAnnotation struct {
+	SampleStart uint64 `json:"core:sample_start"`
+	SampleCount uint64 `json:"core:sample_count"`
+	Label       string `json:"core:label"`
+	Modcod      int    `json:"dvbs2:modcod"`
+	FrameSize   string `json:"dvbs2:frame_size"`
+	Pilots      bool   `json:"dvbs2:pilots"`
+}
+
+// SigmfMeta collects the content of a .sigmf-meta file describing a recording
+// written by SampleWriter, one annotation per PLFRAME.
+type SigmfMeta struct {
+	Global      sigmfGlobal       `json:"global"`
+	Captures    []sigmfCapture    `json:"captures"`
+	Annotations []sigmfAnnotation `json:"annotations"`
+}
+
+func NewSigmfMeta(format string, sampleRate float64, symbolRate float64, rolloff float64) (*SigmfMeta, error) {
+	var m SigmfMeta
+
+	f, ok := sampleFormatMap[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown sample format: \"%s\"", format)
+	}
+
+	m.Global.Datatype = sigmfDatatypeMap[f]
+	m.Global.SampleRate = sampleRate
+	m.Global.Version = sigmfVersion
+	m.Global.Description = "DVB-S2 baseband"
+	m.Global.Extensions = []sigmfExtension{{Name: "dvbs2", Version: sigmfVersion, Optional: true}}
+	m.Global.SymbolRate = symbolRate
+	m.Global.Rolloff = rolloff
+	m.Captures = []sigmfCapture{{SampleStart: 0}}
+	m.Annotations = []sigmfAnnotation{}
+
+	return &m, nil
+}
+
+// Write writes the metadata as JSON.
+func (m *SigmfMeta) Write(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "    ")
+
+	return encoder.Encode(m)
+}
+
+// annotateFrame marks the PLFRAME of sampleCount samples of outFrame written at
+// sampleStart. Positions are moved to the sample rate of the recording, which
+// may differ from the symbol rate times oversampling by resampling.
+func (d *dvb2s) annotateFrame(m *SigmfMeta, sampleStart int, sampleCount int) {
+	scale := m.Global.SampleRate / m.Global.SymbolRate / float64(d.oversampling)
+
+	frameSize := "normal"
+	if d.isShortFrame() {
+		frameSize = "short"
+	}
+
+	m.Annotations = append(m.Annotations, sigmfAnnotation{
+		SampleStart: uint64(math.Round(float64(sampleStart+d.outFrameDelay()) * scale)),
+		SampleCount: uint64(math.Round(float64(sampleCount) * scale)),
+		Label:       modcodName[d.modcod],
+		Modcod:      d.modcod,
+		FrameSize:   frameSize,
+		Pilots:      d.hasPilots(),
+	})
+}
@@ -0,0 +1,208 @@
+package dvb2s
+
+import (
+	"math/rand"
+
+	"github.com/ivantaran/dvb2sgo/channel"
+)
+
+// LinkResult counts errors of a point of the link simulation. Bits are the
+// bits of BBFRAMEs after BCH decoding, a packet is in error when any of its
+// bytes is.
+type LinkResult struct {
+	EsN0         float64
+	EbN0         float64
+	Frames       int
+	FrameErrors  int
+	Bits         int
+	BitErrors    int
+	Packets      int
+	PacketErrors int
+}
+
+func (l LinkResult) Ber() float64 {
+	return float64(l.BitErrors) / float64(l.Bits)
+}
+
+func (l LinkResult) Fer() float64 {
+	return float64(l.FrameErrors) / float64(l.Frames)
+}
+
+func (l LinkResult) Per() float64 {
+	return float64(l.PacketErrors) / float64(l.Packets)
+}
+
+// LinkSimulation passes PLFRAME symbols of random transport stream packets
+// through AWGN into the receiver. Symbols are taken at the output of the ideal
+// matched filter, so shaping is left out.
+type LinkSimulation struct {
+	d      *dvb2s
+	r      *receiver
+	awgn   *channel.Awgn
+	random *rand.Rand
+	sent   []byte // scrambled BBFRAME
+	noisy  []complex128
+	// the last packet counted goes on into the next frame
+	spanning      bool
+	spanningError bool
+}
+
+func NewLinkSimulation(modcod int, fecFrameType string, pilots bool, seed int64) (*LinkSimulation, error) {
+	var s LinkSimulation
+
+	if err := checkModcod(modcod, fecFrameType); err != nil {
+		return nil, err
+	}
+
+	s.d = newDvb2sModcod(modcod, fecFrameType, pilots, 2, false)
+	s.random = rand.New(rand.NewSource(seed))
+	s.d.SetInputStream(&randomTsReader{random: s.random})
+	s.r = newReceiver()
+	s.awgn = channel.NewAwgn(seed + 1)
+	s.sent = make([]byte, len(s.d.bbFrame))
+	s.noisy = make([]complex128, len(s.d.plSymbols))
+
+	return &s, nil
+}
+
+// SetLdpc sets the LDPC decoder of the receiver like Decoder.SetLdpc.
+func (s *LinkSimulation) SetLdpc(iterations int, scale float64, offset float64) error {
+	return s.r.setLdpc(iterations, scale, offset)
+}
+
+// Run simulates frames at Es/N0 in dB until maxFrameErrors frames in error or
+// maxFrames frames.
+func (s *LinkSimulation) Run(esN0 float64, maxFrameErrors int, maxFrames int) (LinkResult, error) {
+	var l LinkResult
+
+	l.EsN0 = esN0
+	l.EbN0 = esN0 - channel.EbN0ToEsN0(0.0, s.d.spectralEfficiency())
+	s.awgn.SetEsN0(esN0, 1.0, 1.0)
+	s.spanning = false
+
+	for l.Frames < maxFrames && l.FrameErrors < maxFrameErrors {
+		if err := s.d.LoadInputStream(); err != nil {
+			return l, err
+		}
+		syncd := s.d.bbHeader.getDataFieldToUserPacketDistance()
+		dfl := s.d.bbHeader.getDataFieldLength()
+
+		s.d.bbFrameScramble()
+		copy(s.sent, s.d.bbFrame)
+		s.d.bchEncode()
+		s.d.ldpcEncode()
+		s.d.bitInterleave()
+		s.d.mapIntoConstellation()
+		s.d.plHeaderEncode()
+		s.d.plScramble()
+
+		copy(s.noisy, s.d.plSymbols)
+		s.awgn.Process(s.noisy)
+
+		received := s.r.fecFrame[:0]
+		uncorrectable := false
+		if _, err := s.r.decodePlFrame(s.noisy); err == nil && s.r.modcod == s.d.modcod && s.r.fecFrameType == s.d.fecFrameType {
+			_, err := s.r.decodeCodeword()
+			uncorrectable = err != nil
+			received = s.r.fecFrame[:len(s.sent)]
+		}
+
+		bitErrors, packets, packetErrors := s.count(received, syncd, dfl)
+		l.Frames++
+		l.Bits += len(s.sent) * 8
+		l.BitErrors += bitErrors
+		l.Packets += packets
+		l.PacketErrors += packetErrors
+		if bitErrors > 0 || uncorrectable {
+			l.FrameErrors++
+		}
+	}
+
+	return l, nil
+}
+
+// count compares the received BBFRAME with the sent one, all bits are in error
+// for a lost PLFRAME. Packets start at SYNCD every UPL bits of the data field
+// and are counted in the frame where they start. Errors in the tail of the
+// packet of the previous frame count for that packet, once.
+func (s *LinkSimulation) count(received []byte, syncd int, dfl int) (int, int, int) {
+	upl := tsPacketSize
+	first := syncd / 8
+	if syncd == 0xffff {
+		first = dfl / 8
+	}
+	packets := (dfl/8 - first + upl - 1) / upl
+
+	bitErrors := 0
+	packetErrors := 0
+	tailError := false
+	lastPacket := -1
+	if len(received) == 0 {
+		bitErrors = len(s.sent) * 8
+		packetErrors = packets
+		tailError = true
+		lastPacket = packets - 1
+	}
+
+	headerError := false
+	for i, value := range received {
+		diff := s.sent[i] ^ value
+		if diff == 0 {
+			continue
+		}
+		for ; diff > 0; diff &= diff - 1 {
+			bitErrors++
+		}
+
+		if i < bbHeaderSize {
+			headerError = true
+			continue
+		}
+		if i >= bbHeaderSize+dfl/8 {
+			continue
+		}
+		if i-bbHeaderSize < first {
+			tailError = true
+			continue
+		}
+		packet := (i - bbHeaderSize - first) / upl
+		if packet != lastPacket {
+			packetErrors++
+			lastPacket = packet
+		}
+	}
+	if headerError {
+		packetErrors = packets
+		tailError = true
+		lastPacket = packets - 1
+	}
+
+	if tailError && s.spanning && !s.spanningError {
+		packetErrors++
+		s.spanningError = true
+	}
+	if packets > 0 {
+		s.spanning = (dfl/8-first)%upl != 0
+		s.spanningError = lastPacket == packets-1
+	}
+
+	return bitErrors, packets, packetErrors
+}
+
+// randomTsReader is an endless stream of TS packets of random payload.
+type randomTsReader struct {
+	random  *rand.Rand
+	pointer int
+}
+
+func (t *randomTsReader) Read(p []byte) (int, error) {
+	for i := range p {
+		if t.pointer == 0 {
+			p[i] = tsSyncByte
+		} else {
+			p[i] = byte(t.random.Intn(256))
+		}
+		t.pointer = (t.pointer + 1) % tsPacketSize
+	}
+	return len(p), nil
+}
@@ -0,0 +1,140 @@
+package dvb2s
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	sampleFormatCs8 = iota
+	sampleFormatCs16le
+	sampleFormatCs16be
+	sampleFormatCf32
+	sampleFormatCf64
+)
+
+var (
+	sampleFormatMap = map[string]int{
+		"cs8":    sampleFormatCs8,
+		"cs16le": sampleFormatCs16le,
+		"cs16be": sampleFormatCs16be,
+		"cf32":   sampleFormatCf32,
+		"cf64":   sampleFormatCf64,
+	}
+
+	sampleFormatSize = map[int]int{ // bytes per I or Q value
+		sampleFormatCs8:    1,
+		sampleFormatCs16le: 2,
+		sampleFormatCs16be: 2,
+		sampleFormatCf32:   4,
+		sampleFormatCf64:   8,
+	}
+
+	sampleFormatFullScale = map[int]float64{
+		sampleFormatCs8:    127.0,
+		sampleFormatCs16le: 32767.0,
+		sampleFormatCs16be: 32767.0,
+		sampleFormatCf32:   1.0,
+		sampleFormatCf64:   1.0,
+	}
+)
+
+// SampleWriter writes interleaved IQ samples, amplitude 1.0 of I or Q maps to
+// the full scale of the format reduced by backoff. Integer values out of range
+// are clipped and counted.
+type SampleWriter struct {
+	writer    *bufio.Writer
+	format    int
+	fullScale float64
+	scale     float64
+	buffer    []byte
+	samples   int
+	clipped   int
+}
+
+func NewSampleWriter(writer io.Writer, format string, backoff float64) (*SampleWriter, error) {
+	var s SampleWriter
+
+	f, ok := sampleFormatMap[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown sample format: \"%s\"", format)
+	}
+
+	s.writer = bufio.NewWriter(writer)
+	s.format = f
+	s.fullScale = sampleFormatFullScale[f]
+	s.scale = s.fullScale * math.Pow(10.0, -backoff/20.0)
+
+	return &s, nil
+}
+
+func (s *SampleWriter) Write(samples []complex128) error {
+	size := 2 * sampleFormatSize[s.format]
+	if len(s.buffer) < len(samples)*size {
+		s.buffer = make([]byte, len(samples)*size)
+	}
+
+	buffer := s.buffer[:len(samples)*size]
+	for i, value := range samples {
+		b := buffer[i*size : (i+1)*size]
+		re := real(value) * s.scale
+		im := imag(value) * s.scale
+
+		switch s.format {
+		case sampleFormatCs8:
+			b[0] = byte(int8(s.clip(re)))
+			b[1] = byte(int8(s.clip(im)))
+		case sampleFormatCs16le:
+			binary.LittleEndian.PutUint16(b[0:], uint16(int16(s.clip(re))))
+			binary.LittleEndian.PutUint16(b[2:], uint16(int16(s.clip(im))))
+		case sampleFormatCs16be:
+			binary.BigEndian.PutUint16(b[0:], uint16(int16(s.clip(re))))
+			binary.BigEndian.PutUint16(b[2:], uint16(int16(s.clip(im))))
+		case sampleFormatCf32:
+			binary.LittleEndian.PutUint32(b[0:], math.Float32bits(float32(re)))
+			binary.LittleEndian.PutUint32(b[4:], math.Float32bits(float32(im)))
+		case sampleFormatCf64:
+			binary.LittleEndian.PutUint64(b[0:], math.Float64bits(re))
+			binary.LittleEndian.PutUint64(b[8:], math.Float64bits(im))
+		default:
+			panic("unknown sample format\n")
+		}
+	}
+
+	s.samples += len(samples)
+	_, err := s.writer.Write(buffer)
+
+	return err
+}
+
+func (s *SampleWriter) clip(value float64) float64 {
+	value = math.Round(value)
+
+	if value > s.fullScale {
+		s.clipped++
+		return s.fullScale
+	}
+	if value < -s.fullScale {
+		s.clipped++
+		return -s.fullScale
+	}
+
+	return value
+}
+
+func (s *SampleWriter) Flush() error {
+	return s.writer.Flush()
+}
+
+// Samples returns the number of samples written.
+func (s *SampleWriter) Samples() int {
+	return s.samples
+}
+
+// Clipped returns the number of clipped I and Q values.
+func (s *SampleWriter) Clipped() int {
+	return s.clipped
+}
@@ -0,0 +1,76 @@
+package dvb2s
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// SampleReader reads interleaved IQ samples in the formats of SampleWriter,
+// the full scale of integer formats maps to amplitude 1.0.
+type SampleReader struct {
+	reader *bufio.Reader
+	format int
+	scale  float64
+	buffer []byte
+}
+
+func NewSampleReader(reader io.Reader, format string) (*SampleReader, error) {
+	var s SampleReader
+
+	f, ok := sampleFormatMap[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown sample format: \"%s\"", format)
+	}
+
+	s.reader = bufio.NewReader(reader)
+	s.format = f
+	s.scale = 1.0 / sampleFormatFullScale[f]
+
+	return &s, nil
+}
+
+// Read fills samples and returns their number, it is io.EOF at the end of the
+// stream. A partial sample at the end is io.ErrUnexpectedEOF.
+func (s *SampleReader) Read(samples []complex128) (int, error) {
+	size := 2 * sampleFormatSize[s.format]
+	if len(s.buffer) < len(samples)*size {
+		s.buffer = make([]byte, len(samples)*size)
+	}
+
+	n, err := io.ReadFull(s.reader, s.buffer[:len(samples)*size])
+	if err == io.ErrUnexpectedEOF && n%size == 0 {
+		err = nil
+	}
+
+	for i := 0; i < n/size; i++ {
+		b := s.buffer[i*size : (i+1)*size]
+		var re, im float64
+
+		switch s.format {
+		case sampleFormatCs8:
+			re = float64(int8(b[0]))
+			im = float64(int8(b[1]))
+		case sampleFormatCs16le:
+			re = float64(int16(binary.LittleEndian.Uint16(b[0:])))
+			im = float64(int16(binary.LittleEndian.Uint16(b[2:])))
+		case sampleFormatCs16be:
+			re = float64(int16(binary.BigEndian.Uint16(b[0:])))
+			im = float64(int16(binary.BigEndian.Uint16(b[2:])))
+		case sampleFormatCf32:
+			re = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[0:])))
+			im = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4:])))
+		case sampleFormatCf64:
+			re = math.Float64frombits(binary.LittleEndian.Uint64(b[0:]))
+			im = math.Float64frombits(binary.LittleEndian.Uint64(b[8:]))
+		default:
+			panic("unknown sample format\n")
+		}
+
+		samples[i] = complex(re*s.scale, im*s.scale)
+	}
+
+	return n / size, err
+}
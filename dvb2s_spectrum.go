@@ -0,0 +1,112 @@
+package dvb2s
+
+import (
+	"math"
+
+	"github.com/ivantaran/dvb2sgo/channel"
+)
+
+// spectrumMaskPoint is a point of the template of the signal spectrum at the
+// output of the modulator, the frequency is relative to the Nyquist frequency
+// of half the symbol rate and the level in dB to the center of the band.
+type spectrumMaskPoint struct {
+	frequency float64
+	level     float64
+}
+
+// spectrumMaskUpper and spectrumMaskLower are points A to S of the template,
+// EN 302 307 figure 13, given for the roll-off 0.35. The upper limit stays at
+// the level of S beyond it, the lower one ends at M.
+var (
+	spectrumMaskUpper = []spectrumMaskPoint{
+		{0.0, 0.25}, {0.2, 0.25}, {0.4, 0.25}, {0.8, 0.15}, {0.9, -0.5}, {1.0, -2.0},
+		{1.2, -8.0}, {1.4, -16.0}, {1.6, -24.0}, {1.8, -35.0}, {2.12, -40.0},
+	}
+	spectrumMaskLower = []spectrumMaskPoint{
+		{0.0, -0.25}, {0.2, -0.4}, {0.4, -0.4}, {0.8, -1.1}, {1.0, -4.0}, {1.2, -11.0},
+	}
+)
+
+const spectrumMaskRolloff float64 = 0.35
+const spectrumMaskReference float64 = 0.2 // Nyquist frequencies, half width
+const spectrumOccupiedFraction float64 = 0.99
+
+// SpectrumReport holds measures of the shaped signal in symbol rates.
+type SpectrumReport struct {
+	OccupiedBandwidth float64
+	AcprLower         float64 // dB
+	AcprUpper         float64 // dB
+	MaskMargin        float64 // dB, the least distance inside the mask
+	MaskFrequency     float64 // of the least margin
+}
+
+// Pass tells whether the spectrum is inside the mask.
+func (s SpectrumReport) Pass() bool {
+	return s.MaskMargin >= 0.0
+}
+
+// CheckSpectrum measures the spectrum of samples at samplesPerSymbol against
+// the template for the roll-off. The 99% bandwidth is occupied, adjacent
+// channels are (1+rolloff) symbol rates wide at the same spacing. Frequencies
+// of the template beyond 1-0.35 Nyquist frequencies are moved toward the
+// Nyquist frequency by rolloff/0.35 to follow the transition band of the
+// roll-off. Levels are relative to the mean density of the center of the band.
+func CheckSpectrum(spectrum *channel.Spectrum, samplesPerSymbol float64, rolloff float64) SpectrumReport {
+	var s SpectrumReport
+
+	width := (1.0 + rolloff) / samplesPerSymbol
+	s.OccupiedBandwidth = spectrum.OccupiedBandwidth(spectrumOccupiedFraction) * samplesPerSymbol
+	s.AcprLower, s.AcprUpper = spectrum.Acpr(width, width)
+
+	nyquist := 0.5 / samplesPerSymbol
+	reference := 0.0
+	bins := 0
+	for i, density := range spectrum.Density {
+		if math.Abs(spectrum.Frequency(i)) <= spectrumMaskReference*nyquist {
+			reference += density
+			bins++
+		}
+	}
+	reference /= float64(bins)
+
+	s.MaskMargin = math.Inf(1)
+	for i, density := range spectrum.Density {
+		f := spectrum.Frequency(i)
+		x := spectrumMaskFrequency(math.Abs(f)/nyquist, rolloff)
+		level := 10.0 * math.Log10(density/reference)
+
+		margin := spectrumMaskLevel(spectrumMaskUpper, x) - level
+		if x <= spectrumMaskLower[len(spectrumMaskLower)-1].frequency {
+			margin = math.Min(margin, level-spectrumMaskLevel(spectrumMaskLower, x))
+		}
+		if margin < s.MaskMargin {
+			s.MaskMargin = margin
+			s.MaskFrequency = f * samplesPerSymbol
+		}
+	}
+
+	return s
+}
+
+// spectrumMaskFrequency maps the relative frequency of the roll-off onto the
+// template of the roll-off 0.35.
+func spectrumMaskFrequency(x float64, rolloff float64) float64 {
+	edge := 1.0 - spectrumMaskRolloff
+	if x <= edge {
+		return x
+	}
+	return math.Max(1.0+(x-1.0)*spectrumMaskRolloff/rolloff, edge)
+}
+
+// spectrumMaskLevel interpolates the level linearly between points, beyond
+// the last point it stays.
+func spectrumMaskLevel(mask []spectrumMaskPoint, x float64) float64 {
+	for i := 1; i < len(mask); i++ {
+		if x <= mask[i].frequency {
+			a := mask[i-1]
+			b := mask[i]
+			return a.level + (b.level-a.level)*(x-a.frequency)/(b.frequency-a.frequency)
+		}
+	}
+	return mask[len(mask)-1].level
+}
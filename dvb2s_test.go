@@ -2,12 +2,23 @@ package dvb2s
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/cmplx"
+	"math/rand"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/ivantaran/dvb2sgo/channel"
 )
 
 const floatTolerance float64 = 1.0e-10
@@ -40,6 +51,14 @@ var bchInitVector = [...]bool{
 	true,
 }
 
+func bitsView(data []byte) []bool {
+	bits := make([]bool, len(data)*8)
+	for i := range bits {
+		bits[i] = getBit(data, i)
+	}
+	return bits
+}
+
 func TestDvb2sCreating(t *testing.T) {
 	t.Run("creating dvb2s object", func(t *testing.T) {
 		if newDvb2s("normal", 2, true) == nil {
@@ -73,10 +92,11 @@ func TestDvb2sBbFrameScramble(t *testing.T) {
 
 		scanner := bufio.NewScanner(file)
 
-		for i := 0; (i < len(d.bbFrame)) && scanner.Scan(); i++ {
+		bbFrame := bitsView(d.bbFrame)
+		for i := 0; (i < len(bbFrame)) && scanner.Scan(); i++ {
 			v, _ := strconv.Atoi(strings.TrimSpace(scanner.Text()))
-			if (v == 1) != d.bbFrame[i] {
-				t.Errorf("[%d]: %t - %t\n", i, v == 1, d.bbFrame[i])
+			if (v == 1) != bbFrame[i] {
+				t.Errorf("[%d]: %t - %t\n", i, v == 1, bbFrame[i])
 				if i > 20 {
 					break
 				}
@@ -87,17 +107,29 @@ func TestDvb2sBbFrameScramble(t *testing.T) {
 
 func TestDvb2sInitBch(t *testing.T) {
 	t.Run("dvb2s init BCH", func(t *testing.T) {
-		d := newDvb2s("normal", 2, true)
-		b := make([]bool, 200) // TODO: 200 is magic number
-		len := d.bchInit(b)
-		if len != 193 {
-			t.Errorf("len != 193: len = %d\n", len)
+		b := bchInit(12, false)
+		if len(b) != 193 {
+			t.Errorf("len != 193: len = %d\n", len(b))
 		}
 		for i := range bchInitVector {
 			if b[i] != bchInitVector[i] {
 				t.Errorf("error at position: %d\n", i)
 			}
 		}
+
+		for _, code := range []struct {
+			t          int
+			shortFrame bool
+			length     int
+		}{{8, false, 129}, {10, false, 161}, {12, true, 169}} {
+			if l := len(bchInit(code.t, code.shortFrame)); l != code.length {
+				t.Errorf("t = %d: len != %d: len = %d\n", code.t, code.length, l)
+			}
+		}
+
+		if &bchInit(10, false)[0] != &bchInit(10, false)[0] {
+			t.Error("generator polynomial is not cached")
+		}
 	})
 }
 
@@ -106,9 +138,7 @@ func TestDvb2sBchEncode(t *testing.T) {
 		d := newDvb2s("normal", 2, true)
 		d.LoadInputData("../../dvb_s2_qpsk_34/2_merger_slicer.txt")
 		d.bbFrameScramble()
-		gpoly := make([]bool, 200) // TODO: 200 is magic number
-		d.bchInit(gpoly)
-		d.bchEncode(gpoly)
+		d.bchEncode()
 
 		file, err := os.Open("../../dvb_s2_qpsk_34/4_bchencoder.txt")
 
@@ -119,16 +149,17 @@ func TestDvb2sBchEncode(t *testing.T) {
 
 		scanner := bufio.NewScanner(file)
 
+		bchFec := bitsView(d.bchFec)
 		var i int
 		j := 1
 		for scanner.Scan() {
-			if i > len(d.bbFrame) {
+			if i > len(d.bbFrame)*8 {
 				v, _ := strconv.Atoi(strings.TrimSpace(scanner.Text()))
-				if (v == 1) != d.bchFec[j] {
-					t.Errorf("%t : %t\n", v == 1, d.bchFec[j])
+				if (v == 1) != bchFec[j] {
+					t.Errorf("%t : %t\n", v == 1, bchFec[j])
 				}
 				j++
-				if j == len(d.bchFec) {
+				if j == len(bchFec) {
 					fmt.Printf("BCH len == 192: position = %d\n", i+1)
 					break
 				}
@@ -143,10 +174,7 @@ func TestDvb2sLdpcEncode(t *testing.T) {
 		d := newDvb2s("normal", 2, true)
 		d.LoadInputData("../../dvb_s2_qpsk_34/2_merger_slicer.txt")
 		d.bbFrameScramble()
-		gpoly := make([]bool, 200) // TODO: 200 is magic number
-
-		d.bchInit(gpoly)
-		d.bchEncode(gpoly)
+		d.bchEncode()
 		d.ldpcEncode()
 
 		file, err := os.Open("../../dvb_s2_qpsk_34/5_ldpcencoder.txt")
@@ -158,16 +186,17 @@ func TestDvb2sLdpcEncode(t *testing.T) {
 
 		scanner := bufio.NewScanner(file)
 
-		buffer := make([]bool, len(d.fecFrame))
-		for i := 0; i < len(d.fecFrame) && scanner.Scan(); i++ {
+		buffer := make([]bool, len(d.fecFrame)*8)
+		for i := 0; i < len(buffer) && scanner.Scan(); i++ {
 			v, _ := strconv.Atoi(strings.TrimSpace(scanner.Text()))
 			buffer[i] = v == 1
 		}
 
-		ldpcFec := buffer[len(d.bchBlock):]
+		ldpcFec := buffer[len(d.bchBlock)*8:]
+		dLdpcFec := bitsView(d.ldpcFec)
 		for i := range ldpcFec {
-			if ldpcFec[i] != d.ldpcFec[i] {
-				t.Errorf("[%d] %t : %t\n", i, ldpcFec[i], d.ldpcFec[i])
+			if ldpcFec[i] != dLdpcFec[i] {
+				t.Errorf("[%d] %t : %t\n", i, ldpcFec[i], dLdpcFec[i])
 			}
 		}
 	})
@@ -178,11 +207,9 @@ func TestDvb2sMapIntoConstellation(t *testing.T) {
 		d := newDvb2s("normal", 2, true)
 		d.LoadInputData("../../dvb_s2_qpsk_34/2_merger_slicer.txt")
 		d.bbFrameScramble()
-		gpoly := make([]bool, 200) // TODO: 200 is magic number
-
-		d.bchInit(gpoly)
-		d.bchEncode(gpoly)
+		d.bchEncode()
 		d.ldpcEncode()
+		d.bitInterleave()
 		d.mapIntoConstellation()
 
 		file, err := os.Open("../../dvb_s2_qpsk_34/7_mapper.txt")
@@ -220,11 +247,9 @@ func TestDvb2sPlHeaderEncode(t *testing.T) {
 		d := newDvb2s("normal", 2, true)
 		d.LoadInputData("../../dvb_s2_qpsk_34/2_merger_slicer.txt")
 		d.bbFrameScramble()
-		gpoly := make([]bool, 200) // TODO: 200 is magic number
-
-		d.bchInit(gpoly)
-		d.bchEncode(gpoly)
+		d.bchEncode()
 		d.ldpcEncode()
+		d.bitInterleave()
 		d.mapIntoConstellation()
 		d.plHeaderEncode()
 
@@ -264,11 +289,9 @@ func TestDvb2sPlFrameScramble(t *testing.T) {
 		d := newDvb2s("normal", 2, true)
 		d.LoadInputData("../../dvb_s2_qpsk_34/2_merger_slicer.txt")
 		d.bbFrameScramble()
-		gpoly := make([]bool, 200) // TODO: 200 is magic number
-
-		d.bchInit(gpoly)
-		d.bchEncode(gpoly)
+		d.bchEncode()
 		d.ldpcEncode()
+		d.bitInterleave()
 		d.mapIntoConstellation()
 		d.plHeaderEncode()
 		d.plScramble()
@@ -312,11 +335,9 @@ func TestDvb2sOutInterpolateBbShape(t *testing.T) {
 		d := newDvb2s("normal", 2, false)
 		d.LoadInputData("../../dvb_s2_qpsk_34/2_merger_slicer.txt")
 		d.bbFrameScramble()
-		gpoly := make([]bool, 200) // TODO: 200 is magic number
-
-		d.bchInit(gpoly)
-		d.bchEncode(gpoly)
+		d.bchEncode()
 		d.ldpcEncode()
+		d.bitInterleave()
 		d.mapIntoConstellation()
 		d.plHeaderEncode()
 		d.plScramble()
@@ -357,7 +378,7 @@ func TestDvb2sOutInterpolateBbShape(t *testing.T) {
 
 func TestDvb2sBbHeader(t *testing.T) {
 	t.Run("TestDvb2sBbHeader", func(t *testing.T) {
-		h := newBbHeader()
+		h := newBbHeader(48408)
 		if h == nil {
 			t.Error("bbheader is nil")
 		}
@@ -371,10 +392,11 @@ func TestDvb2sBbHeader(t *testing.T) {
 
 		scanner := bufio.NewScanner(file)
 
-		for i := 0; i < len(h.bitstream) && scanner.Scan(); i++ {
+		bitstream := bitsView(h.bytes[:])
+		for i := 0; i < len(bitstream) && scanner.Scan(); i++ {
 			v, _ := strconv.Atoi(strings.TrimSpace(scanner.Text()))
-			if h.bitstream[i] != (v > 0) {
-				t.Errorf("[%2d] %t != %t\n", i, h.bitstream[i], v > 0)
+			if bitstream[i] != (v > 0) {
+				t.Errorf("[%2d] %t != %t\n", i, bitstream[i], v > 0)
 			}
 		}
 
@@ -399,12 +421,1855 @@ func TestDvb2sCrc8Encode(t *testing.T) {
 
 		scanner := bufio.NewScanner(file)
 
-		for i := 0; i < len(d.inFrame) && scanner.Scan(); i++ {
+		inFrame := bitsView(d.inFrame)
+		for i := 0; i < len(inFrame) && scanner.Scan(); i++ {
 			v, _ := strconv.Atoi(strings.TrimSpace(scanner.Text()))
-			if d.inFrame[i] != (v > 0) {
-				t.Errorf("[%4d] %t != %t\n", i, d.inFrame[i], v > 0)
+			if inFrame[i] != (v > 0) {
+				t.Errorf("[%4d] %t != %t\n", i, inFrame[i], v > 0)
+			}
+		}
+
+	})
+}
+
+func makeTsPackets(count int, packetSize int) []byte {
+	stream := make([]byte, 0, count*packetSize)
+	for i := 0; i < count; i++ {
+		packet := make([]byte, packetSize)
+		packet[0] = tsSyncByte
+		for j := 1; j < tsPacketSize; j++ {
+			packet[j] = byte(i+j) & 0x3f
+		}
+		for j := tsPacketSize; j < packetSize; j++ {
+			packet[j] = 0xff
+		}
+		stream = append(stream, packet...)
+	}
+	return stream
+}
+
+func TestDvb2sTsReader(t *testing.T) {
+	t.Run("TestDvb2sTsReader", func(t *testing.T) {
+		for _, packetSize := range []int{tsPacketSize, tsRsPacketSize} {
+			packets := makeTsPackets(8, packetSize)
+
+			stream := append([]byte{0x00, tsSyncByte, 0x12}, packets[:3*packetSize]...)
+			stream = append(stream, packets[3*packetSize:3*packetSize+100]...) // broken packet
+			stream = append(stream, packets[4*packetSize:]...)
+
+			r := newTsReader(bytes.NewReader(stream))
+			packet := make([]byte, tsPacketSize)
+			expected := []int{0, 1, 2, 4, 5, 6, 7}
+			for _, n := range expected {
+				if err := r.readPacket(packet); err != nil {
+					t.Fatalf("packet size %d: %v\n", packetSize, err)
+				}
+				if !bytes.Equal(packet, packets[n*packetSize:n*packetSize+tsPacketSize]) {
+					t.Errorf("packet size %d: packet %d is corrupted\n", packetSize, n)
+				}
+			}
+			if err := r.readPacket(packet); err != io.EOF {
+				t.Errorf("packet size %d: %v != %v\n", packetSize, err, io.EOF)
+			}
+			if r.packetSize != packetSize {
+				t.Errorf("packet size %d != %d\n", r.packetSize, packetSize)
+			}
+			if r.syncLosses != 1 {
+				t.Errorf("packet size %d: sync losses %d != 1\n", packetSize, r.syncLosses)
+			}
+		}
+	})
+}
+
+func TestDvb2sLoadInputStream(t *testing.T) {
+	t.Run("TestDvb2sLoadInputStream", func(t *testing.T) {
+		d := newDvb2s("normal", 2, false)
+		packets := makeTsPackets(40, tsPacketSize)
+		d.SetInputStream(bytes.NewReader(packets))
+
+		data := make([]byte, 0, len(packets))
+		crc := uint8(0)
+		for n := 0; n < len(packets); n += tsPacketSize {
+			data = append(data, crc)
+			data = append(data, packets[n+1:n+tsPacketSize]...)
+			crc = d.bbHeader.crc8Encode(packets[n+1 : n+tsPacketSize])
+		}
+
+		upl := d.bbHeader.getUserPacketLength()
+		header := len(d.bbHeader.bytes)
+		for frame := 0; len(data) > 0; frame++ {
+			if err := d.LoadInputStream(); err != nil {
+				t.Fatal(err)
+			}
+
+			dfl := d.bbHeader.getDataFieldLength() / 8
+			if dfl != len(d.inFrame) && dfl != len(data) {
+				t.Errorf("frame %d: dfl = %d\n", frame, dfl)
+			}
+			syncd := d.bbHeader.getDataFieldToUserPacketDistance()
+			if syncd != (upl-frame*len(d.inFrame)*8%upl)%upl {
+				t.Errorf("frame %d: syncd = %d\n", frame, syncd)
+			}
+			if d.bbHeader.crc8Encode(d.bbFrame[:header]) != 0 {
+				t.Errorf("frame %d: header crc error\n", frame)
+			}
+			if !bytes.Equal(d.bbFrame[header:header+dfl], data[:dfl]) {
+				t.Fatalf("frame %d: data field is corrupted\n", frame)
+			}
+			for i := header + dfl; i < len(d.bbFrame); i++ {
+				if d.bbFrame[i] != 0x00 {
+					t.Fatalf("frame %d: padding [%d] is not zero\n", frame, i)
+				}
+			}
+			data = data[dfl:]
+		}
+
+		if err := d.LoadInputStream(); err != io.EOF {
+			t.Errorf("%v != %v\n", err, io.EOF)
+		}
+	})
+}
+
+func TestDvb2sSampleWriter(t *testing.T) {
+	t.Run("TestDvb2sSampleWriter", func(t *testing.T) {
+		samples := []complex128{complex(1.0, -0.5), complex(2.0, -2.0)}
+
+		expected := map[string][]byte{
+			"cs8":    {0x7f, 0xc0, 0x7f, 0x81},
+			"cs16le": {0xff, 0x7f, 0x00, 0xc0, 0xff, 0x7f, 0x01, 0x80},
+			"cs16be": {0x7f, 0xff, 0xc0, 0x00, 0x7f, 0xff, 0x80, 0x01},
+			"cf32": {
+				0x00, 0x00, 0x80, 0x3f, 0x00, 0x00, 0x00, 0xbf,
+				0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0xc0,
+			},
+		}
+
+		for format, value := range expected {
+			var buffer bytes.Buffer
+			s, err := NewSampleWriter(&buffer, format, 0.0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Write(samples); err != nil {
+				t.Fatal(err)
+			}
+			s.Flush()
+			if !bytes.Equal(buffer.Bytes(), value) {
+				t.Errorf("%s: % x != % x\n", format, buffer.Bytes(), value)
+			}
+			clipped := 2
+			if format == "cf32" {
+				clipped = 0
+			}
+			if s.clipped != clipped || s.samples != len(samples) {
+				t.Errorf("%s: clipped %d, samples %d\n", format, s.clipped, s.samples)
+			}
+		}
+
+		var buffer bytes.Buffer
+		s, _ := NewSampleWriter(&buffer, "cf64", 20.0*math.Log10(2.0))
+		s.Write(samples[:1])
+		s.Flush()
+		re := math.Float64frombits(binary.LittleEndian.Uint64(buffer.Bytes()))
+		if math.Abs(re-0.5) > floatTolerance {
+			t.Errorf("backoff: %f != %f\n", re, 0.5)
+		}
+
+		if _, err := NewSampleWriter(&buffer, "cs12", 0.0); err == nil {
+			t.Error("unknown format is accepted")
+		}
+	})
+}
+
+func TestDvb2sSigmfMeta(t *testing.T) {
+	t.Run("TestDvb2sSigmfMeta", func(t *testing.T) {
+		d := newDvb2s("normal", 2, false)
+		d.SetInputStream(bytes.NewReader(makeTsPackets(40, tsPacketSize)))
+
+		var data, meta bytes.Buffer
+		s, _ := NewSampleWriter(&data, "cs16le", 6.0)
+		m, err := NewSigmfMeta("cs16le", 2.0e6, 1.0e6, 0.35)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			if err := d.LoadInputStream(); err != nil {
+				break
+			}
+			d.encodeFrame()
+			d.annotateFrame(m, s.samples, len(d.outFrame))
+			s.Write(d.outFrame)
+		}
+		s.Flush()
+
+		if err := m.Write(&meta); err != nil {
+			t.Fatal(err)
+		}
+
+		var result map[string]interface{}
+		if err := json.Unmarshal(meta.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+
+		global := result["global"].(map[string]interface{})
+		if global["core:datatype"] != "ci16_le" || global["core:sample_rate"] != 2.0e6 {
+			t.Errorf("global: %v\n", global)
+		}
+
+		annotations := result["annotations"].([]interface{})
+		if len(annotations) != 2 {
+			t.Fatalf("annotations: %d != 2\n", len(annotations))
+		}
+		for i, value := range annotations {
+			a := value.(map[string]interface{})
+			start := float64(i*len(d.outFrame) + d.outFrameDelay())
+			if a["core:sample_start"] != start || a["core:label"] != "QPSK 3/4" || a["dvbs2:pilots"] != false {
+				t.Errorf("annotation %d: %v\n", i, a)
+			}
+		}
+
+		if data.Len() != 2*len(d.outFrame)*4 {
+			t.Errorf("data length: %d\n", data.Len())
+		}
+	})
+
+	t.Run("Encoder", func(t *testing.T) {
+		d := newDvb2s("normal", 2, false)
+		m, err := NewSigmfMeta("cf32", 3.0e6, 1.0e6, 0.35)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := NewEncoder("normal", 2, 2)
+		e.SetSigmfMeta(m)
+		for i := 0; i < 2; i++ {
+			err := e.Encode(context.Background(), bytes.NewReader(makeTsPackets(40, tsPacketSize)), func(samples []complex128) error {
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// samples of oversampling 2 are resampled to 3 per symbol
+		if len(m.Annotations) != 4 {
+			t.Fatalf("annotations: %d != 4\n", len(m.Annotations))
+		}
+		for i, a := range m.Annotations {
+			start := uint64(math.Round(float64(i*len(d.outFrame)+d.outFrameDelay()) * 1.5))
+			if a.SampleStart != start || a.SampleCount != uint64(len(d.outFrame)*3/2) || a.Modcod != 7 {
+				t.Errorf("annotation %d: %v\n", i, a)
+			}
+		}
+	})
+}
+
+func TestDvb2sCrc8Table(t *testing.T) {
+	t.Run("TestDvb2sCrc8Table", func(t *testing.T) {
+		h := newBbHeader(48408)
+		data := makeTsPackets(1, tsPacketSize)[1:]
+
+		// bit by bit reference with the reflected register, sent LSB first
+		sr := uint8(0x00)
+		for _, value := range bitsView(data) {
+			swap := (sr&0x01 > 0) != value
+			sr >>= 1
+			if swap {
+				sr ^= 0xab
+			}
+		}
+		var crc uint8
+		for i := 0; i < 8; i, sr = i+1, sr>>1 {
+			crc = (crc << 1) | (sr & 0x01)
+		}
+
+		if h.crc8Encode(data) != crc {
+			t.Errorf("%02x != %02x\n", h.crc8Encode(data), crc)
+		}
+	})
+}
+
+func BenchmarkDvb2sEncodeFrame(b *testing.B) {
+	d := newDvb2s("normal", 2, false)
+	d.SetInputStream(bytes.NewReader(makeTsPackets(40, tsPacketSize)))
+	d.LoadInputStream()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.encodeFrame()
+	}
+}
+
+func TestDvb2sBchEncoder(t *testing.T) {
+	t.Run("TestDvb2sBchEncoder", func(t *testing.T) {
+		for frameType, codes := range bchCodeMap {
+			for rate, code := range codes {
+				b := newBchEncoder(bchInit(code.t, frameType == "small"))
+				if b.parityLength != code.nbch-code.kbch {
+					t.Errorf("%s %s: parity length %d\n", frameType, rate, b.parityLength)
+					continue
+				}
+
+				data := make([]byte, code.nbch/8)
+				for i := 0; i < code.kbch/8; i++ {
+					data[i] = byte(i*131 + i>>3)
+				}
+				parity := data[code.kbch/8:]
+				paritySerial := make([]byte, len(parity))
+
+				b.encode(data[:code.kbch/8], parity)
+				b.encodeSerial(data[:code.kbch/8], paritySerial)
+				if !bytes.Equal(parity, paritySerial) {
+					t.Errorf("%s %s: % x != % x\n", frameType, rate, parity, paritySerial)
+				}
+
+				b.encodeSerial(data, paritySerial)
+				for _, value := range paritySerial {
+					if value != 0x00 {
+						t.Errorf("%s %s: codeword is not divisible by the generator\n", frameType, rate)
+						break
+					}
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkDvb2sBchEncode(b *testing.B) {
+	d := newDvb2s("normal", 2, false)
+	for i := 0; i < b.N; i++ {
+		d.bchEncoder.encode(d.bbFrame, d.bchFec)
+	}
+}
+
+// bchEncodeBool is the bool slice BCH encoder the table driven one replaced,
+// kept as the reference of the benchmark. gpoly is the generator of bchInit.
+func bchEncodeBool(gpoly []bool, bbFrame []bool, bchFec []bool) {
+
+	for i := range bchFec {
+		bchFec[i] = false
+	}
+
+	len := len(bchFec)
+	for _, value := range bbFrame {
+		fb := bchFec[0] != value
+		for i := 0; i < len-1; i++ {
+			bchFec[i] = (gpoly[i+1] && fb) != bchFec[i+1]
+		}
+		bchFec[len-1] = fb
+	}
+
+}
+
+func TestDvb2sBchEncodeBool(t *testing.T) {
+	t.Run("TestDvb2sBchEncodeBool", func(t *testing.T) {
+		d := newDvb2s("normal", 2, false)
+		for i := range d.bbFrame {
+			d.bbFrame[i] = byte(i*131 + i>>3)
+		}
+		d.bchEncode()
+
+		bchFec := make([]bool, len(d.bchFec)*8)
+		bchEncodeBool(bchInitVector[:], bitsView(d.bbFrame), bchFec)
+
+		for i, value := range bitsView(d.bchFec) {
+			if value != bchFec[i] {
+				t.Errorf("[%d] %t != %t\n", i, value, bchFec[i])
+				break
+			}
+		}
+	})
+}
+
+func BenchmarkDvb2sBchEncodeBool(b *testing.B) {
+	d := newDvb2s("normal", 2, false)
+	bbFrame := bitsView(d.bbFrame)
+	bchFec := make([]bool, len(d.bchFec)*8)
+	for i := 0; i < b.N; i++ {
+		bchEncodeBool(bchInitVector[:], bbFrame, bchFec)
+	}
+}
+
+func TestDvb2sLdpcEncoder(t *testing.T) {
+	t.Run("TestDvb2sLdpcEncoder", func(t *testing.T) {
+		d := newDvb2s("normal", 2, false)
+		for i := range d.bchBlock {
+			d.bchBlock[i] = byte(i*167 + i>>5)
+		}
+
+		parity := make([]byte, len(d.ldpcFec))
+		d.ldpcEncoder.encodeSerial(d.bchBlock, parity)
+		d.ldpcEncode()
+
+		if !bytes.Equal(parity, d.ldpcFec) {
+			t.Error("word parallel parity differs from bit by bit parity")
+		}
+
+		if newLdpcCode("normal", "3/4") != d.ldpcEncoder.code {
+			t.Error("LDPC code is not cached")
+		}
+	})
+}
+
+func BenchmarkDvb2sLdpcEncode(b *testing.B) {
+	d := newDvb2s("normal", 2, false)
+	b.SetBytes(int64(len(d.bbFrame)))
+	for i := 0; i < b.N; i++ {
+		d.ldpcEncoder.encode(d.bchBlock, d.ldpcFec)
+	}
+}
+
+func BenchmarkDvb2sLdpcEncodeSerial(b *testing.B) {
+	d := newDvb2s("normal", 2, false)
+	for i := range d.bchBlock {
+		d.bchBlock[i] = byte(i)
+	}
+	b.SetBytes(int64(len(d.bbFrame)))
+	for i := 0; i < b.N; i++ {
+		d.ldpcEncoder.encodeSerial(d.bchBlock, d.ldpcFec)
+	}
+}
+
+func TestDvb2sEncoder(t *testing.T) {
+	t.Run("TestDvb2sEncoder", func(t *testing.T) {
+		packets := makeTsPackets(300, tsPacketSize)
+
+		d := newDvb2s("normal", 2, false)
+		d.SetInputStream(bytes.NewReader(packets))
+		var expected [][]complex128
+		for d.LoadInputStream() == nil {
+			d.encodeFrame()
+			expected = append(expected, append([]complex128(nil), d.outFrame...))
+		}
+
+		e := NewEncoder("normal", 2, 4)
+		n := 0
+		err := e.Encode(context.Background(), bytes.NewReader(packets), func(samples []complex128) error {
+			if n >= len(expected) {
+				return fmt.Errorf("unexpected frame %d", n)
+			}
+			for i := range samples {
+				if cmplx.Abs(samples[i]-expected[n][i]) > floatTolerance {
+					return fmt.Errorf("frame %d: [%d] %f != %f", n, i, samples[i], expected[n][i])
+				}
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(expected) {
+			t.Errorf("frames: %d != %d\n", n, len(expected))
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		err = e.Encode(ctx, bytes.NewReader(packets), func(samples []complex128) error {
+			cancel()
+			return nil
+		})
+		if err != context.Canceled {
+			t.Errorf("%v != %v\n", err, context.Canceled)
+		}
+
+		stop := errors.New("stop")
+		err = e.Encode(context.Background(), bytes.NewReader(packets), func(samples []complex128) error {
+			return stop
+		})
+		if err != stop {
+			t.Errorf("%v != %v\n", err, stop)
+		}
+	})
+}
+
+// tsTestStream repeats packets endlessly or up to limit bytes.
+type tsTestStream struct {
+	packets []byte
+	offset  int
+	limit   int
+}
+
+func (s *tsTestStream) Read(p []byte) (int, error) {
+	if s.limit <= 0 {
+		return 0, io.EOF
+	}
+	if len(p) > s.limit {
+		p = p[:s.limit]
+	}
+	n := copy(p, s.packets[s.offset:])
+	s.offset = (s.offset + n) % len(s.packets)
+	s.limit -= n
+	return n, nil
+}
+
+func TestDvb2sEncoderAllocations(t *testing.T) {
+	t.Run("TestDvb2sEncoderAllocations", func(t *testing.T) {
+		d := newDvb2s("normal", 2, false)
+		d.SetInputStream(&tsTestStream{packets: makeTsPackets(10, tsPacketSize), limit: math.MaxInt64})
+		allocs := testing.AllocsPerRun(10, func() {
+			d.LoadInputStream()
+			d.encodeFrame()
+		})
+		if allocs != 0 {
+			t.Errorf("serial encoding: %f allocations per frame\n", allocs)
+		}
+
+		e := NewEncoder("normal", 2, 2)
+		encode := func(frames int) func() {
+			return func() {
+				stream := &tsTestStream{packets: makeTsPackets(10, tsPacketSize), limit: frames * len(d.inFrame)}
+				e.Encode(context.Background(), stream, func(samples []complex128) error { return nil })
+			}
+		}
+
+		// the setup of the pipeline is the same for any number of frames
+		short := testing.AllocsPerRun(5, encode(4))
+		long := testing.AllocsPerRun(5, encode(24))
+		if allocs := (long - short) / 20; allocs != 0 {
+			t.Errorf("pipeline encoding: %f allocations per frame\n", allocs)
+		}
+	})
+}
+
+func BenchmarkDvb2sEncoder(b *testing.B) {
+	e := NewEncoder("normal", 2, runtime.NumCPU())
+	stream := &tsTestStream{packets: makeTsPackets(10, tsPacketSize), limit: b.N * len(e.d.inFrame)}
+
+	b.SetBytes(int64(len(e.d.inFrame)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	if err := e.Encode(context.Background(), stream, func(samples []complex128) error { return nil }); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestDvb2sReceiver(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	noise := func(symbols []complex128, sigma float64) {
+		for i := range symbols {
+			symbols[i] += complex(sigma*random.NormFloat64(), sigma*random.NormFloat64())
+		}
+	}
+
+	d := newDvb2s("normal", 2, false)
+	d.SetInputStream(bytes.NewReader(makeTsPackets(40, tsPacketSize)))
+	if err := d.LoadInputStream(); err != nil {
+		t.Fatal(err)
+	}
+	d.bbFrameScramble()
+	d.bchEncode()
+	d.ldpcEncode()
+	d.bitInterleave()
+	d.mapIntoConstellation()
+	payload := append([]complex128(nil), d.plFrame...)
+
+	t.Run("plscode", func(t *testing.T) {
+		r := newReceiver()
+		for modcod := 1; modcod < len(modcodName); modcod++ {
+			for frameType := 0; frameType < 4; frameType++ {
+				d.modcod = modcod
+				d.fecFrameType = frameType
+				d.plHeaderEncode()
+
+				var soft [64]float64
+				for i := range soft {
+					n := i + len(plHeaderSof)
+					soft[i] = real(d.plHeader[n]*cmplx.Conj(plHeaderSymbol(false, n))) + 0.5*random.NormFloat64()
+					if plHeaderScrambleTable[i] {
+						soft[i] = -soft[i]
+					}
+				}
+				plsCode, _ := plsDecode(soft[:])
+				if plsCode != modcod<<2|frameType {
+					t.Errorf("PLSCODE %02x != %02x\n", plsCode, modcod<<2|frameType)
+				}
+
+				symbols := make([]complex128, slotSize)
+				copy(symbols, d.plHeader)
+				r.decodePlFrame(symbols)
+				if r.modcod != modcod || r.fecFrameType != frameType {
+					t.Errorf("MODCOD %d/%d != %d/%d\n", r.modcod, r.fecFrameType, modcod, frameType)
+				}
+			}
+		}
+		d.modcod = 7
+		d.fecFrameType = 0
+	})
+
+	t.Run("frame", func(t *testing.T) {
+		d.plHeaderEncode()
+		d.plScramble()
+
+		phase := cmplx.Rect(1.0, 2.0)
+		symbols := make([]complex128, len(d.plSymbols)+slotSize)
+		for i, value := range d.plSymbols {
+			symbols[i] = value * phase
+		}
+		noise(symbols, 0.05)
+
+		r := newReceiver()
+		n, err := r.decodePlFrame(symbols)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(d.plSymbols) {
+			t.Errorf("size %d != %d\n", n, len(d.plSymbols))
+		}
+		if r.modcod != 7 || r.fecFrameType != 0 {
+			t.Errorf("MODCOD %d/%d != 7/0\n", r.modcod, r.fecFrameType)
+		}
+		if cmplx.Abs(r.phase-phase) > 0.05 {
+			t.Errorf("phase %v != %v\n", r.phase, phase)
+		}
+		if len(r.plFrame) != len(payload) || len(r.pilots) != 0 {
+			t.Fatalf("payload %d/%d != %d/0\n", len(r.plFrame), len(r.pilots), len(payload))
+		}
+		for i, value := range r.plFrame {
+			if cmplx.Abs(value-payload[i]) > 0.4 {
+				t.Fatalf("symbol %d: %v != %v\n", i, value, payload[i])
+			}
+		}
+	})
+
+	t.Run("pilots", func(t *testing.T) {
+		d.fecFrameType = 1
+		d.plHeaderEncode()
+		d.fecFrameType = 0
+
+		pilot := complex(map1Pi4, map1Pi4)
+		symbols := append([]complex128(nil), d.plHeader...)
+		for i, value := range payload {
+			if i > 0 && i%(pilotPeriod*slotSize) == 0 {
+				for j := 0; j < pilotBlockSize; j++ {
+					symbols = append(symbols, pilot)
+				}
+			}
+			symbols = append(symbols, value)
+		}
+		for i := range symbols[slotSize:] {
+			symbols[slotSize+i] = plRotate(symbols[slotSize+i], plScrambleTable[i])
+		}
+
+		r := newReceiver()
+		n, err := r.decodePlFrame(symbols)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(symbols) || !r.hasPilots() {
+			t.Errorf("size %d != %d, pilots %v\n", n, len(symbols), r.hasPilots())
+		}
+		if len(r.pilots) != 22*pilotBlockSize || len(r.plFrame) != len(payload) {
+			t.Fatalf("payload %d/%d != %d/%d\n", len(r.plFrame), len(r.pilots), len(payload), 22*pilotBlockSize)
+		}
+		for _, value := range r.pilots {
+			if cmplx.Abs(value-pilot) > 1e-9 {
+				t.Fatalf("pilot %v != %v\n", value, pilot)
+			}
+		}
+		for i, value := range r.plFrame {
+			if cmplx.Abs(value-payload[i]) > 1e-9 {
+				t.Fatalf("symbol %d: %v != %v\n", i, value, payload[i])
+			}
+		}
+	})
+
+	t.Run("no sof", func(t *testing.T) {
+		symbols := make([]complex128, slotSize)
+		noise(symbols, 1.0)
+
+		r := newReceiver()
+		if _, err := r.decodePlFrame(symbols); err != errPlSofNotFound {
+			t.Errorf("%v != %v\n", err, errPlSofNotFound)
+		}
+		if _, err := r.decodePlFrame(symbols[:10]); err != io.ErrShortBuffer {
+			t.Errorf("%v != %v\n", err, io.ErrShortBuffer)
+		}
+	})
+}
+
+func TestDvb2sConstellation(t *testing.T) {
+	t.Run("points", func(t *testing.T) {
+		for modcod := 1; modcod < len(modcodName); modcod++ {
+			c := newConstellation(modcod)
+			if len(c.points) != 1<<uint(c.bitsPerSymbol) {
+				t.Fatalf("%s: %d points\n", modcodName[modcod], len(c.points))
+			}
+
+			energy := 0.0
+			minDistance := math.Inf(1)
+			for i, a := range c.points {
+				energy += real(a)*real(a) + imag(a)*imag(a)
+				for _, b := range c.points[i+1:] {
+					minDistance = math.Min(minDistance, cmplx.Abs(a-b))
+				}
+			}
+			if math.Abs(energy/float64(len(c.points))-1.0) > 1e-12 {
+				t.Errorf("%s: mean energy %f\n", modcodName[modcod], energy/float64(len(c.points)))
+			}
+			if minDistance < 0.1 {
+				t.Errorf("%s: distance %f\n", modcodName[modcod], minDistance)
+			}
+		}
+
+		c := newConstellation(19)
+		if r := cmplx.Abs(c.points[0]) / cmplx.Abs(c.points[12]); math.Abs(r-2.85) > 1e-12 {
+			t.Errorf("16APSK 3/4 gamma %f != 2.85\n", r)
+		}
+		c = newConstellation(24)
+		if r := cmplx.Abs(c.points[8]) / cmplx.Abs(c.points[17]); math.Abs(r-5.27) > 1e-12 {
+			t.Errorf("32APSK 3/4 gamma %f != 5.27\n", r)
+		}
+	})
+
+	t.Run("qpsk", func(t *testing.T) {
+		c := newConstellation(7)
+		symbols := []complex128{complex(0.3, -0.8), complex(-1.2, 0.1)}
+		llr := make([]float64, 4)
+		noiseVariance := 0.25
+
+		c.demap(symbols, noiseVariance, true, llr)
+		for i, value := range symbols {
+			re := 2.0 * math.Sqrt(2.0) * real(value) / noiseVariance
+			im := 2.0 * math.Sqrt(2.0) * imag(value) / noiseVariance
+			if math.Abs(llr[2*i]-re) > 1e-9 || math.Abs(llr[2*i+1]-im) > 1e-9 {
+				t.Errorf("%v: %f %f != %f %f\n", value, llr[2*i], llr[2*i+1], re, im)
 			}
 		}
+	})
 
+	t.Run("demap", func(t *testing.T) {
+		random := rand.New(rand.NewSource(1))
+		for _, modcod := range []int{7, 14, 19, 24} {
+			c := newConstellation(modcod)
+			m := c.bitsPerSymbol
+			symbols := make([]complex128, len(c.points))
+			for i, point := range c.points {
+				symbols[i] = point + complex(0.01*random.NormFloat64(), 0.01*random.NormFloat64())
+			}
+
+			for _, exact := range []bool{true, false} {
+				llr := make([]float64, len(symbols)*m)
+				c.demap(symbols, 0.01, exact, llr)
+				for i := range symbols {
+					for k := 0; k < m; k++ {
+						bit := i&(1<<uint(m-1-k)) > 0
+						if (llr[i*m+k] < 0) != bit {
+							t.Errorf("%s exact %v: point %d bit %d llr %f\n", modcodName[modcod], exact, i, k, llr[i*m+k])
+						}
+					}
+				}
+			}
+
+			exact := make([]float64, len(symbols)*m)
+			maxLog := make([]float64, len(symbols)*m)
+			c.demap(symbols, 1.0, true, exact)
+			c.demap(symbols, 1.0, false, maxLog)
+			for i := range exact {
+				if math.Abs(exact[i]-maxLog[i]) > math.Log(float64(len(c.points))) {
+					t.Errorf("%s: exact %f max-log %f\n", modcodName[modcod], exact[i], maxLog[i])
+				}
+			}
+		}
+	})
+}
+
+func TestDvb2sLdpcDecoder(t *testing.T) {
+	d := newDvb2s("normal", 2, false)
+	for i := range d.bchBlock {
+		d.bchBlock[i] = byte(i*167 + i>>5)
+	}
+	d.ldpcEncode()
+
+	bpsk := func(ebN0 float64, seed int64) ([]float64, int) {
+		random := rand.New(rand.NewSource(seed))
+		rate := float64(len(d.bchBlock)) / float64(len(d.fecFrame))
+		sigma := math.Sqrt(1.0 / (2.0 * rate * math.Pow(10.0, ebN0/10.0)))
+		llr := make([]float64, len(d.fecFrame)*8)
+		errors := 0
+		for i := range llr {
+			value := 1.0
+			if getBit(d.fecFrame, i) {
+				value = -1.0
+			}
+			value += sigma * random.NormFloat64()
+			if (value < 0.0) != getBit(d.fecFrame, i) {
+				errors++
+			}
+			llr[i] = 2.0 * value / (sigma * sigma)
+		}
+		return llr, errors
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		llr, _ := bpsk(100.0, 1)
+		decoder := newLdpcDecoder("normal", "3/4")
+		codeword := make([]byte, len(d.fecFrame))
+		if !decoder.decode(llr, codeword) || decoder.iterations != 0 {
+			t.Errorf("valid %v after %d iterations\n", decoder.syndrome(), decoder.iterations)
+		}
+		if !bytes.Equal(codeword, d.fecFrame) {
+			t.Error("codeword differs")
+		}
+	})
+
+	t.Run("awgn", func(t *testing.T) {
+		decoder := newLdpcDecoder("normal", "3/4")
+		offset := decoder.clone()
+		offset.scale = 1.0
+		offset.offset = 0.5
+
+		for _, decoder := range []*ldpcDecoder{decoder, offset} {
+			llr, errors := bpsk(3.0, 2)
+			codeword := make([]byte, len(d.fecFrame))
+			valid := decoder.decode(llr, codeword)
+			if !valid || !bytes.Equal(codeword, d.fecFrame) {
+				t.Errorf("%d errors not corrected, valid %v after %d iterations\n", errors, valid, decoder.iterations)
+			}
+			if errors < 1000 || decoder.iterations < 2 {
+				t.Errorf("%d errors corrected after %d iterations\n", errors, decoder.iterations)
+			}
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		llr, _ := bpsk(0.0, 3)
+		decoder := newLdpcDecoder("normal", "3/4")
+		decoder.maxIterations = 5
+		codeword := make([]byte, len(d.fecFrame))
+		if decoder.decode(llr, codeword) || decoder.iterations != 5 {
+			t.Errorf("valid after %d iterations\n", decoder.iterations)
+		}
+	})
+}
+
+func BenchmarkDvb2sLdpcDecode(b *testing.B) {
+	d := newDvb2s("normal", 2, false)
+	d.ldpcEncode()
+	llr := make([]float64, len(d.fecFrame)*8)
+	for i := range llr {
+		llr[i] = 1.0 + 0.5*float64(i%7-3)
+	}
+	decoder := newLdpcDecoder("normal", "3/4")
+	decoder.maxIterations = 10
+	codeword := make([]byte, len(d.fecFrame))
+
+	b.SetBytes(int64(len(d.fecFrame)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decoder.decode(llr, codeword)
+	}
+}
+
+func TestDvb2sBchDecoder(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for frameType, codes := range bchCodeMap {
+		for rate, code := range codes {
+			t.Run(frameType+" "+rate, func(t *testing.T) {
+				e := newBchEncoder(bchInit(code.t, frameType == "small"))
+				b := newBchDecoder(code.t, frameType == "small")
+
+				data := make([]byte, code.nbch/8)
+				random.Read(data[:code.kbch/8])
+				e.encode(data[:code.kbch/8], data[code.kbch/8:])
+
+				received := make([]byte, len(data))
+				for errors := 0; errors <= code.t; errors++ {
+					copy(received, data)
+					for _, i := range random.Perm(code.nbch)[:errors] {
+						flipBit(received, i)
+					}
+
+					corrected, err := b.decode(received)
+					if err != nil || corrected != errors || !bytes.Equal(received, data) {
+						t.Errorf("%d errors: %d corrected, %v\n", errors, corrected, err)
+					}
+				}
+
+				copy(received, data)
+				for _, i := range random.Perm(code.nbch)[:3*code.t] {
+					flipBit(received, i)
+				}
+				if _, err := b.decode(received); err != errBchUncorrectable {
+					t.Errorf("%d errors: %v != %v\n", 3*code.t, err, errBchUncorrectable)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDvb2sBchDecode(b *testing.B) {
+	code := bchCodeMap["normal"]["3/4"]
+	e := newBchEncoder(bchInit(code.t, false))
+	decoder := newBchDecoder(code.t, false)
+	data := make([]byte, code.nbch/8)
+	e.encode(data[:code.kbch/8], data[code.kbch/8:])
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < code.t; j++ {
+			flipBit(data, j*4001)
+		}
+		decoder.decode(data)
+	}
+}
+
+func TestDvb2sParseBbHeader(t *testing.T) {
+	d := newDvb2s("normal", 2, false)
+	d.SetInputStream(bytes.NewReader(makeTsPackets(40, tsPacketSize)))
+	for i := 0; i < 2; i++ {
+		if err := d.LoadInputStream(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frame := append([]byte(nil), d.bbFrame...)
+
+	d.bbFrameScramble()
+	bbFrameDescramble(d.bbFrame)
+	if !bytes.Equal(frame, d.bbFrame) {
+		t.Fatal("descrambled BBFRAME differs")
+	}
+
+	h, err := ParseBbHeader(d.bbFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := BbHeader{
+		StreamType:               TransportStream,
+		SingleInputStream:        true,
+		ConstantCodingModulation: true,
+		Rolloff:                  TransmissionRolloffFactor035,
+		UserPacketLength:         188 * 8,
+		DataFieldLength:          d.bbHeader.getDataFieldLength(),
+		SyncByte:                 0x47,
+		SyncDistance:             d.bbHeader.getDataFieldToUserPacketDistance(),
+	}
+	if *h != expected {
+		t.Errorf("%+v != %+v\n", *h, expected)
+	}
+	if h.SyncDistance == 0 || h.SyncDistance == 0xffff {
+		t.Errorf("SYNCD %d of the second frame\n", h.SyncDistance)
+	}
+
+	d.bbFrame[3] ^= 0x01
+	if _, err := ParseBbHeader(d.bbFrame); err != ErrBbHeaderCrc {
+		t.Errorf("%v != %v\n", err, ErrBbHeaderCrc)
+	}
+	if _, err := ParseBbHeader(d.bbFrame[:4]); err == nil {
+		t.Error("short BBFRAME is accepted")
+	}
+}
+
+func TestDvb2sTsDeframer(t *testing.T) {
+	stream := makeTsPackets(100, tsPacketSize)
+	d := newDvb2s("normal", 2, false)
+	d.SetInputStream(bytes.NewReader(stream))
+	frames := [][]byte{}
+	for d.LoadInputStream() == nil {
+		frames = append(frames, append([]byte(nil), d.bbFrame...))
+	}
+
+	deframe := func(frames [][]byte) (*tsDeframer, []byte) {
+		var output bytes.Buffer
+		deframer := newTsDeframer(&output)
+		for _, frame := range frames {
+			h, err := ParseBbHeader(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := deframer.push(h, frame); err != nil {
+				t.Fatal(err)
+			}
+		}
+		deframer.flush()
+		return deframer, output.Bytes()
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		deframer, output := deframe(frames)
+		if !bytes.Equal(output, stream) {
+			t.Errorf("%d bytes of %d packets differ\n", len(output), deframer.packets)
+		}
+		if deframer.crcErrors != 0 || deframer.syncLosses != 0 {
+			t.Errorf("%d CRC errors, %d sync losses\n", deframer.crcErrors, deframer.syncLosses)
+		}
+	})
+
+	t.Run("crc", func(t *testing.T) {
+		corrupted := make([][]byte, len(frames))
+		for i, frame := range frames {
+			corrupted[i] = append([]byte(nil), frame...)
+		}
+		corrupted[1][bbHeaderSize+500] ^= 0x01
+
+		deframer, output := deframe(corrupted)
+		if deframer.crcErrors != 1 || len(output) != len(stream) {
+			t.Fatalf("%d CRC errors, %d bytes\n", deframer.crcErrors, len(output))
+		}
+		for i := 0; i < len(output); i += tsPacketSize {
+			marked := output[i+1]&tsTransportErrorIndicator > 0
+			if marked != !bytes.Equal(output[i+2:i+tsPacketSize], stream[i+2:i+tsPacketSize]) {
+				t.Errorf("packet %d marked %v\n", i/tsPacketSize, marked)
+			}
+		}
+	})
+
+	t.Run("lost frame", func(t *testing.T) {
+		deframer, _ := deframe([][]byte{frames[0], frames[2], frames[3]})
+		if deframer.syncLosses != 1 || deframer.crcErrors != 1 {
+			t.Errorf("%d CRC errors, %d sync losses\n", deframer.crcErrors, deframer.syncLosses)
+		}
+	})
+
+	t.Run("null packets", func(t *testing.T) {
+		packets := makeTsPackets(4, tsPacketSize)
+		dnp := []byte{0, 2, 0, 1}
+
+		h := newBbHeader(bchCodeMap["normal"]["3/4"].kbch)
+		h.matype1[0] |= NullPacketDeletionYes
+		data := []byte{}
+		crc := uint8(0)
+		for i, count := range dnp {
+			packet := packets[i*tsPacketSize : (i+1)*tsPacketSize]
+			data = append(data, crc)
+			data = append(data, packet[1:]...)
+			data = append(data, count)
+			crc = crc8Checksum(packet[1:])
+		}
+		h.setDataFieldLength(len(data) * 8)
+		h.update()
+		frame := append(h.bytes[:], data...)
+
+		var output bytes.Buffer
+		deframer := newTsDeframer(&output)
+		parsed, err := ParseBbHeader(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := deframer.push(parsed, frame); err != nil {
+			t.Fatal(err)
+		}
+		deframer.flush()
+
+		expected := []byte{}
+		for i, count := range dnp {
+			for j := 0; j < int(count); j++ {
+				expected = append(expected, deframer.nullPacket[:]...)
+			}
+			expected = append(expected, packets[i*tsPacketSize:(i+1)*tsPacketSize]...)
+		}
+		if !bytes.Equal(output.Bytes(), expected) || deframer.nullPackets != 3 || deframer.crcErrors != 0 {
+			t.Errorf("%d packets, %d null packets, %d CRC errors\n", deframer.packets, deframer.nullPackets, deframer.crcErrors)
+		}
+	})
+}
+
+// TestDvb2sLoopback covers the MODCODs and frame sizes of SupportedModcods,
+// the ones having LDPC tables, so far the 3/4 MODCODs of normal frames.
+func TestDvb2sLoopback(t *testing.T) {
+	stream := makeTsPackets(120, tsPacketSize)
+	awgn := channel.NewAwgn(1)
+	esN0 := 20.0
+
+	for _, frameType := range []string{"normal", "small"} {
+		for _, modcod := range SupportedModcods(frameType) {
+			for _, pilots := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s %s pilots %v", modcodName[modcod], frameType, pilots), func(t *testing.T) {
+					d := newDvb2sModcod(modcod, frameType, pilots, 2, false)
+					d.SetInputStream(bytes.NewReader(stream))
+					samples := []complex128{}
+					for d.LoadInputStream() == nil {
+						d.encodeFrame()
+						samples = append(samples, d.outFrame...)
+					}
+					for i := 0; i < 2*d.firFilter.delay(); i++ {
+						samples = append(samples, d.firFilter.fir(0))
+					}
+
+					rotation := cmplx.Rect(1.0, 0.7)
+					for i, value := range samples {
+						samples[i] = value * rotation
+					}
+					awgn.SetEsN0(esN0, channel.MeanPower(samples), float64(d.oversampling))
+					awgn.Process(samples)
+
+					matched := newFir(d.firFilter.coefficients)
+					symbols := []complex128{}
+					for i, value := range samples {
+						value = matched.fir(value)
+						if i >= 2*matched.delay() && (i-2*matched.delay())%d.oversampling == 0 {
+							symbols = append(symbols, value)
+						}
+					}
+
+					var output bytes.Buffer
+					r := newReceiver()
+					deframer := newTsDeframer(&output)
+					for pos := 0; pos+slotSize < len(symbols); {
+						n, err := r.decodePlFrame(symbols[pos:])
+						if err != nil {
+							t.Fatalf("symbol %d: %v\n", pos, err)
+						}
+						if r.modcod != modcod || r.isShortFrame() != (frameType == "small") || r.hasPilots() != pilots {
+							t.Fatalf("%s short %v pilots %v\n", modcodName[r.modcod], r.isShortFrame(), r.hasPilots())
+						}
+						h, bbFrame, err := r.decodeFec()
+						if err != nil {
+							t.Fatalf("symbol %d: %v\n", pos, err)
+						}
+						if !r.ldpcValid || r.bchCorrected != 0 {
+							t.Errorf("LDPC valid %v, %d BCH corrections\n", r.ldpcValid, r.bchCorrected)
+						}
+						if err := deframer.push(h, bbFrame); err != nil {
+							t.Fatal(err)
+						}
+						pos += n
+					}
+					deframer.flush()
+
+					if !bytes.Equal(output.Bytes(), stream) {
+						t.Errorf("%d of %d bytes, %d CRC errors\n", output.Len(), len(stream), deframer.crcErrors)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestDvb2sModcodUsage(t *testing.T) {
+	modcods, frames := ModcodUsage()
+	for _, frameType := range []string{"normal", "small"} {
+		supported := SupportedModcods(frameType)
+		if strings.Contains(frames, frameType) != (len(supported) > 0) {
+			t.Errorf("%s in \"%s\"\n", frameType, frames)
+		}
+		for _, modcod := range supported {
+			if !strings.Contains(modcods, fmt.Sprintf("%d (%s)", modcod, modcodName[modcod])) {
+				t.Errorf("%s is not in \"%s\"\n", modcodName[modcod], modcods)
+			}
+		}
+	}
+}
+
+func TestDvb2sSpectralEfficiency(t *testing.T) {
+	for _, test := range []struct {
+		modcod     int
+		pilots     bool
+		efficiency float64
+	}{
+		{7, false, 1.487473},
+		{7, true, 48328.0 / 33282.0},
+		{14, false, 2.228124},
+		{19, false, 2.966728},
+		{24, false, 3.703295},
+	} {
+		d := newDvb2sModcod(test.modcod, "normal", test.pilots, 2, false)
+		if math.Abs(d.spectralEfficiency()-test.efficiency) > 1e-6 {
+			t.Errorf("%s pilots %v: %f != %f\n", modcodName[test.modcod], test.pilots, d.spectralEfficiency(), test.efficiency)
+		}
+	}
+}
+
+func TestDvb2sLinkSimulation(t *testing.T) {
+	if _, err := NewLinkSimulation(7, "small", false, 1); err == nil {
+		t.Error("unsupported code is accepted")
+	}
+
+	s, err := NewLinkSimulation(7, "normal", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := s.Run(5.0, 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Frames != 4 || l.FrameErrors != 0 || l.BitErrors != 0 || l.PacketErrors != 0 {
+		t.Errorf("Es/N0 5 dB: %+v\n", l)
+	}
+	dfl := s.d.bbHeader.getDataFieldLength() / 8
+	if l.Bits != 4*48408 || l.Packets != (4*dfl+tsPacketSize-1)/tsPacketSize {
+		t.Errorf("%d bits, %d packets\n", l.Bits, l.Packets)
+	}
+	if math.Abs(l.EbN0-5.0+10.0*math.Log10(1.487473)) > 1e-5 {
+		t.Errorf("Eb/N0 %f\n", l.EbN0)
+	}
+
+	l, err = s.Run(1.0, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Frames != 2 || l.Fer() != 1.0 || l.Ber() < 1e-3 || l.Per() < 0.5 {
+		t.Errorf("Es/N0 1 dB: %+v\n", l)
+	}
+
+	// packets spanning frames are counted once, in the frame where they start
+	s.sent = make([]byte, bbHeaderSize+476)
+	s.spanning = false
+	for i, test := range []struct {
+		syncd        int
+		errorAt      int // of the data field, -1 for a lost frame
+		packets      int
+		packetErrors int
+	}{
+		{0, 400, 3, 1},
+		{88 * 8, 10, 3, 0},
+		{176 * 8, 5, 2, 1},
+		{0xffff, -1, 0, 1},
+	} {
+		var received []byte
+		if test.errorAt >= 0 {
+			received = make([]byte, len(s.sent))
+			received[bbHeaderSize+test.errorAt] = 0x01
+		}
+		_, packets, packetErrors := s.count(received, test.syncd, 476*8)
+		if packets != test.packets || packetErrors != test.packetErrors {
+			t.Errorf("frame %d: %d packets, %d in error\n", i, packets, packetErrors)
+		}
+	}
+}
+
+func TestDvb2sPredistortion(t *testing.T) {
+	t.Run("static", func(t *testing.T) {
+		d := newDvb2sModcod(19, "normal", false, 2, false)
+		amplifier := channel.NewSalehTwta()
+		drive := 0.8
+		radius, phase := newStaticPredistortion(d.constellation, amplifier, drive)
+		d.setPredistortion(radius, phase)
+
+		inner := d.points[12]
+		outer := d.points[0]
+		innerAmplitude, innerPhase := amplifier.Response(drive * cmplx.Abs(inner))
+		outerAmplitude, outerPhase := amplifier.Response(drive * cmplx.Abs(outer))
+		if math.Abs(outerAmplitude/innerAmplitude-apsk16Gamma[modcodCodeRate[19]][0]) > 1e-9 {
+			t.Errorf("ratio of rings %f\n", outerAmplitude/innerAmplitude)
+		}
+		turn := innerPhase + cmplx.Phase(inner) - cmplx.Phase(d.constellation.points[12])
+		if math.Abs(turn-outerPhase) > 1e-9 || math.Abs(radius[1]-1.0) > 1e-9 || math.Abs(phase[1]) > 1e-9 {
+			t.Errorf("phases of rings %f %f\n", turn, outerPhase)
+		}
+
+		d.setPredistortion(nil, nil)
+		if &d.points[0] != &d.constellation.points[0] {
+			t.Error("constellation is not restored")
+		}
+	})
+
+	t.Run("dynamic", func(t *testing.T) {
+		d := newDvb2sModcod(19, "normal", false, 2, false)
+		hpa := channel.NewHpa(channel.NewSalehTwta())
+		hpa.SetInputBackoff(3.0, 1.0/float64(d.oversampling))
+
+		random := rand.New(rand.NewSource(2))
+		labels := make([]uint8, 20000)
+		for i := range labels {
+			labels[i] = uint8(random.Intn(16))
+		}
+
+		linear := d.predistortionMer(hpa, labels)
+		d.trainPredistortion(hpa, 6, 1)
+		trained := d.predistortionMer(hpa, labels)
+		if trained < linear+3.0 {
+			t.Errorf("MER %f dB, without predistortion %f dB\n", trained, linear)
+		}
+
+		d.SetInputStream(&randomTsReader{random: random})
+		if err := d.LoadInputStream(); err != nil {
+			t.Fatal(err)
+		}
+		d.bbFrameScramble()
+		d.bchEncode()
+		d.ldpcEncode()
+		d.bitInterleave()
+		d.mapIntoConstellation()
+		m := uint(d.constellation.bitsPerSymbol)
+		for _, j := range []int{0, 1000, len(d.bitFrame)*8/int(m) - 1} {
+			if d.plFrame[d.plFramePosition(j)] != d.predistortion.table[predistortionContext(d.labels, j, m)] {
+				t.Errorf("symbol %d is not predistorted\n", j)
+			}
+		}
+	})
+
+	t.Run("Encoder", func(t *testing.T) {
+		e, err := NewEncoderModcod(19, "normal", false, 2, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.SetPredistortion([]float64{1.0}, []float64{0.0}); err == nil {
+			t.Error("wrong number of rings is accepted")
+		}
+		if err := e.SetPredistortion([]float64{1.1, 1.0}, []float64{0.1, 0.0}); err != nil {
+			t.Fatal(err)
+		}
+		if cmplx.Abs(e.d.points[12]-e.d.constellation.points[12]*cmplx.Rect(1.1, 0.1)) > floatTolerance {
+			t.Errorf("inner ring %f\n", e.d.points[12])
+		}
+
+		// the shaped signal of power 1/oversampling^2 drives the amplifier
+		power := 0.0
+		for _, value := range e.d.firFilter.coefficients {
+			power += value * value
+		}
+		if math.Abs(power/2.0-0.25) > 0.01 {
+			t.Errorf("power of the shaped signal %f\n", power/2.0)
+		}
+
+		hpa := channel.NewHpa(channel.NewSalehTwta())
+		hpa.SetInputBackoff(3.0, 0.25)
+		random := rand.New(rand.NewSource(3))
+		labels := make([]uint8, 20000)
+		for i := range labels {
+			labels[i] = uint8(random.Intn(16))
+		}
+
+		e.SetPredistortion(nil, nil)
+		linear := e.d.predistortionMer(hpa, labels)
+		e.TrainPredistortion(channel.NewSalehTwta(), 3.0, 4)
+		trained := e.d.predistortionMer(hpa, labels)
+		if trained < linear+3.0 {
+			t.Errorf("MER %f dB, without predistortion %f dB\n", trained, linear)
+		}
+	})
+}
+
+func TestDvb2sFrontEnd(t *testing.T) {
+	stream := makeTsPackets(150, tsPacketSize)
+
+	for _, test := range []struct {
+		modcod       int
+		pilots       bool
+		oversampling int
+		frequency    float64 // cycles per symbol
+		ppm          float64
+		esN0         float64
+		gold         int
+	}{
+		{7, true, 2, 0.02, 50.0, 8.0, 0},
+		{7, false, 4, -0.01, -50.0, 8.0, 0},
+		{14, true, 2, 0.005, 20.0, 12.0, 131071},
+		{19, true, 4, -0.005, 0.0, 16.0, 0},
+	} {
+		name := fmt.Sprintf("%s pilots %v oversampling %d gold %d", modcodName[test.modcod], test.pilots, test.oversampling, test.gold)
+		t.Run(name, func(t *testing.T) {
+			scrambling, err := plGoldScrambling(test.gold)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := newDvb2sModcod(test.modcod, "normal", test.pilots, test.oversampling, false)
+			d.plScrambling = scrambling
+			d.SetInputStream(bytes.NewReader(stream))
+			samples := make([]complex128, 1001)
+			frames := 0
+			for d.LoadInputStream() == nil {
+				d.encodeFrame()
+				samples = append(samples, d.outFrame...)
+				frames++
+			}
+			for i := 0; i < 2*d.firFilter.delay(); i++ {
+				samples = append(samples, d.firFilter.fir(0))
+			}
+
+			awgn := channel.NewAwgn(1)
+			awgn.SetEsN0(test.esN0, channel.MeanPower(samples), float64(test.oversampling))
+			samples = channel.Chain{
+				channel.NewFrequencyOffset(test.frequency/float64(test.oversampling), 0.0),
+				channel.NewWienerPhaseNoise(1e-6, 1),
+				channel.NewClockOffset(test.ppm),
+				awgn,
+			}.Process(samples)
+
+			var output bytes.Buffer
+			r := newReceiver()
+			r.plScrambling = scrambling
+			deframer := newTsDeframer(&output)
+			decoded := 0
+			decode := func(symbols []complex128) error {
+				if _, err := r.decodePlFrame(symbols); err != nil {
+					return nil
+				}
+				h, bbFrame, err := r.decodeFec()
+				if err != nil {
+					return nil
+				}
+				if r.modcod != test.modcod || r.hasPilots() != test.pilots {
+					t.Errorf("%s pilots %v\n", modcodName[r.modcod], r.hasPilots())
+				}
+				decoded++
+				return deframer.push(h, bbFrame)
+			}
+
+			f := newFrontEnd(test.oversampling)
+			f.plScrambling = scrambling
+			for i := 0; i < len(samples); i += 10007 {
+				end := i + 10007
+				if end > len(samples) {
+					end = len(samples)
+				}
+				if err := f.process(samples[i:end], decode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := f.flush(decode); err != nil {
+				t.Fatal(err)
+			}
+			deframer.flush()
+
+			// the first PLHEADER comes during the acquisition of timing
+			if decoded < frames-1 || f.syncLosses != 0 {
+				t.Errorf("%d of %d frames, %d sync losses\n", decoded, frames, f.syncLosses)
+			}
+			if !bytes.HasSuffix(stream, output.Bytes()) || len(stream)-output.Len() > len(stream)/frames+tsPacketSize {
+				t.Errorf("%d of %d bytes\n", output.Len(), len(stream))
+			}
+		})
+	}
+}
+
+func TestDvb2sQuality(t *testing.T) {
+	c := newConstellation(19)
+	random := rand.New(rand.NewSource(3))
+	labels := make([]uint8, 20000)
+	ideal := make([]complex128, len(labels))
+	for i := range labels {
+		labels[i] = uint8(random.Intn(len(c.points)))
+		ideal[i] = c.points[labels[i]]
+	}
+
+	t.Run("awgn", func(t *testing.T) {
+		symbols := make([]complex128, len(ideal))
+		for i, value := range ideal {
+			symbols[i] = value * 0.5
+		}
+		awgn := channel.NewAwgn(4)
+		awgn.SetEsN0(15.0, 0.25, 1.0)
+		awgn.Process(symbols)
+
+		q := measureQuality(c, symbols, labels)
+		if q.Symbols != len(symbols) || math.Abs(q.Mer-15.0) > 0.2 || math.Abs(q.Snr-15.0) > 0.5 {
+			t.Errorf("MER %f dB, SNR %f dB\n", q.Mer, q.Snr)
+		}
+		if evm := 100.0 * math.Pow(10.0, -q.Mer/20.0) / cmplx.Abs(c.points[0]); math.Abs(q.EvmRms-evm) > 0.1 || q.EvmPeak < 2.0*q.EvmRms {
+			t.Errorf("EVM %f %f%%, expected %f%%\n", q.EvmRms, q.EvmPeak, evm)
+		}
+
+		decided := measureQuality(c, symbols, nil)
+		if math.Abs(decided.Mer-q.Mer) > 0.3 {
+			t.Errorf("MER by decisions %f dB, by labels %f dB\n", decided.Mer, q.Mer)
+		}
+	})
+
+	t.Run("snr 32APSK", func(t *testing.T) {
+		c := newConstellation(24)
+		labels := make([]uint8, 64800)
+		symbols := make([]complex128, len(labels))
+		for i := range labels {
+			labels[i] = uint8(random.Intn(len(c.points)))
+			symbols[i] = c.points[labels[i]]
+		}
+		awgn := channel.NewAwgn(6)
+		awgn.SetEsN0(30.0, 1.0, 1.0)
+		awgn.Process(symbols)
+
+		// the kurtosis of the whole constellation misses the labels sent by
+		// enough to spoil SNR beyond some 25 dB
+		for _, q := range []Quality{measureQuality(c, symbols, labels), measureQuality(c, symbols, nil)} {
+			if math.Abs(q.Snr-30.0) > 1.5 {
+				t.Errorf("SNR %f dB, MER %f dB\n", q.Snr, q.Mer)
+			}
+		}
+	})
+
+	t.Run("rings", func(t *testing.T) {
+		turn := cmplx.Rect(1.0, 5.0*math.Pi/180.0)
+		symbols := make([]complex128, len(ideal))
+		for i, value := range ideal {
+			symbols[i] = value * cmplx.Rect(2.0, 1.0)
+			if c.rings[labels[i]] == 0 {
+				symbols[i] *= turn * 0.9
+			}
+		}
+
+		q := measureQuality(c, symbols, labels)
+		if len(q.Rings) != 2 || q.Rings[0].Symbols+q.Rings[1].Symbols != len(symbols) || q.Rings[0].Radius >= q.Rings[1].Radius {
+			t.Fatalf("rings %v\n", q.Rings)
+		}
+		amplitude := q.Rings[0].Amplitude - q.Rings[1].Amplitude
+		phase := q.Rings[0].Phase - q.Rings[1].Phase
+		if math.Abs(amplitude-20.0*math.Log10(0.9)) > 1e-9 || math.Abs(phase-5.0) > 1e-9 {
+			t.Errorf("ring error %f dB %f degrees\n", amplitude, phase)
+		}
+	})
+
+	t.Run("receiver", func(t *testing.T) {
+		d := newDvb2sModcod(19, "normal", true, 2, false)
+		d.SetInputStream(&randomTsReader{random: random})
+		if err := d.LoadInputStream(); err != nil {
+			t.Fatal(err)
+		}
+		d.bbFrameScramble()
+		d.bchEncode()
+		d.ldpcEncode()
+		d.bitInterleave()
+		d.mapIntoConstellation()
+		d.plHeaderEncode()
+		d.plScramble()
+
+		symbols := make([]complex128, len(d.plSymbols))
+		for i, value := range d.plSymbols {
+			symbols[i] = value * cmplx.Rect(0.7, -2.0)
+		}
+		awgn := channel.NewAwgn(5)
+		awgn.SetEsN0(18.0, 0.49, 1.0)
+		awgn.Process(symbols)
+
+		r := newReceiver()
+		if _, err := r.decodePlFrame(symbols); err != nil {
+			t.Fatal(err)
+		}
+		q := r.quality()
+		if q.Symbols != len(d.bitFrame)*8/c.bitsPerSymbol || math.Abs(q.Mer-18.0) > 0.5 {
+			t.Errorf("MER %f dB of %d symbols\n", q.Mer, q.Symbols)
+		}
+	})
+}
+
+func TestDvb2sSpectrum(t *testing.T) {
+	for _, test := range []struct {
+		oversampling        int
+		interpolateByRepeat bool
+		rolloff             float64
+		mask                float64 // roll-off of the mask
+		pass                bool
+	}{
+		{2, false, 0.35, 0.35, true},
+		{4, false, 0.35, 0.35, true},
+		{4, false, 0.35, 0.20, false},
+		{4, true, 0.35, 0.35, false},
+		{3, false, 0.25, 0.25, true},
+		{4, false, 0.20, 0.20, true},
+	} {
+		name := fmt.Sprintf("oversampling %d repeat %t rolloff %.2f mask %.2f", test.oversampling, test.interpolateByRepeat, test.rolloff, test.mask)
+		t.Run(name, func(t *testing.T) {
+			d := newDvb2sModcod(7, "normal", false, test.oversampling, test.interpolateByRepeat)
+			d.setRolloff(test.rolloff)
+			d.SetInputStream(&randomTsReader{random: rand.New(rand.NewSource(1))})
+			welch := channel.NewWelch(128)
+			for i := 0; i < 8; i++ {
+				if err := d.LoadInputStream(); err != nil {
+					t.Fatal(err)
+				}
+				d.encodeFrame()
+				welch.Process(d.outFrame)
+			}
+
+			s := CheckSpectrum(welch.Spectrum(), float64(test.oversampling), test.mask)
+			if s.Pass() != test.pass {
+				t.Errorf("margin %f dB at %f\n", s.MaskMargin, s.MaskFrequency)
+			}
+			if s.OccupiedBandwidth < 1.0 || s.OccupiedBandwidth > 1.0+test.rolloff {
+				t.Errorf("occupied bandwidth %f\n", s.OccupiedBandwidth)
+			}
+			if test.pass && (s.AcprLower > -30.0 || s.AcprUpper > -30.0) {
+				t.Errorf("ACPR %f %f dB\n", s.AcprLower, s.AcprUpper)
+			}
+		})
+	}
+}
+
+func TestDvb2sEncoderOptions(t *testing.T) {
+	t.Run("errors", func(t *testing.T) {
+		for _, test := range []struct {
+			modcod       int
+			fecFrameType string
+			oversampling int
+		}{
+			{0, "normal", 2},
+			{29, "normal", 2},
+			{7, "big", 2},
+			{7, "normal", 1},
+		} {
+			if _, err := NewEncoderModcod(test.modcod, test.fecFrameType, false, test.oversampling, 1); err == nil {
+				t.Errorf("no error for %+v\n", test)
+			}
+		}
+
+		e, err := NewEncoderModcod(19, "normal", true, 3, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.SetRolloff(0.3) == nil || e.SetGoldCode(-1) == nil || e.SetGoldCode(plGoldCodes) == nil {
+			t.Error("no error for wrong options")
+		}
+	})
+
+	t.Run("rolloff", func(t *testing.T) {
+		e, _ := NewEncoderModcod(7, "normal", false, 3, 1)
+		if err := e.SetRolloff(0.20); err != nil {
+			t.Fatal(err)
+		}
+		e.d.SetInputStream(&randomTsReader{random: rand.New(rand.NewSource(1))})
+		if err := e.d.LoadInputStream(); err != nil {
+			t.Fatal(err)
+		}
+		if h, err := ParseBbHeader(e.d.bbFrame); err != nil || h.Rolloff != TransmissionRolloffFactor020 {
+			t.Errorf("BBHEADER %v %v\n", h, err)
+		}
+
+		h := e.d.firFilter.coefficients
+		center := len(h) / 2
+		sum := 0.0
+		for _, value := range h {
+			sum += value
+		}
+		if len(h) != firRrcSpan*3 || math.Abs(sum-1.0) > floatTolerance {
+			t.Errorf("%d taps, gain %f\n", len(h), sum)
+		}
+		for k := 1; k < center; k++ {
+			if math.Abs(h[center-k]-h[center+k]) > floatTolerance {
+				t.Fatalf("asymmetric tap %d\n", k)
+			}
+		}
+
+		// the raised cosine of the pair of filters crosses zero at symbols
+		peak := 0.0
+		for n := range h {
+			peak += h[n] * h[n]
+		}
+		for k := 1; k < 8; k++ {
+			value := 0.0
+			for n := 3 * k; n < len(h); n++ {
+				value += h[n] * h[n-3*k]
+			}
+			if math.Abs(value) > 2e-3*peak {
+				t.Errorf("interference %f at symbol %d\n", value/peak, k)
+			}
+		}
+	})
+
+	t.Run("gold code", func(t *testing.T) {
+		e, _ := NewEncoderModcod(7, "normal", false, 2, 1)
+		if err := e.SetGoldCode(0); err != nil || &e.d.plScrambling[0] != &plScrambleTable[0] {
+			t.Error("gold code 0 is not the default sequence")
+		}
+		if err := e.SetGoldCode(131071); err != nil {
+			t.Fatal(err)
+		}
+		table := newPlScrambleTable(plFrameMaxSize, 131071)
+		equal := 0
+		for i, r := range e.d.plScrambling {
+			if r != table[i] {
+				t.Fatalf("scrambling differs at %d\n", i)
+			}
+			if r == plScrambleTable[i] {
+				equal++
+			}
+		}
+		if equal > len(table)*3/10 {
+			t.Errorf("%d of %d equal to gold code 0\n", equal, len(table))
+		}
+
+		d := e.d
+		d.SetInputStream(&randomTsReader{random: rand.New(rand.NewSource(1))})
+		if err := d.LoadInputStream(); err != nil {
+			t.Fatal(err)
+		}
+		d.encodeFrame()
+		for i, value := range d.plFrame {
+			j := d.labels[i]
+			if plRotate(value, 4-table[i]) != d.points[j] {
+				t.Fatalf("symbol %d is not scrambled by the gold code\n", i)
+			}
+		}
+	})
+}
+
+func TestDvb2sSampleReader(t *testing.T) {
+	samples := []complex128{0.5 - 0.25i, -1.0 + 1.0i, 0.0, 0.125 - 0.75i}
+
+	for format, f := range sampleFormatMap {
+		var buffer bytes.Buffer
+		w, _ := NewSampleWriter(&buffer, format, 0.0)
+		w.Write(samples)
+		w.Flush()
+
+		r, err := NewSampleReader(&buffer, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := make([]complex128, 6)
+		n, err := r.Read(read[:3])
+		if n != 3 || err != nil {
+			t.Fatalf("%s: %d samples, %v\n", format, n, err)
+		}
+		n, err = r.Read(read[3:])
+		if n != 1 || err != nil {
+			t.Fatalf("%s: %d samples, %v\n", format, n, err)
+		}
+		if n, err = r.Read(read); n != 0 || err != io.EOF {
+			t.Errorf("%s: %d samples, %v at the end\n", format, n, err)
+		}
+
+		step := 1.0 / sampleFormatFullScale[f]
+		for i, value := range samples {
+			if cmplx.Abs(read[i]-value) > step {
+				t.Errorf("%s: %v != %v\n", format, read[i], value)
+			}
+		}
+	}
+
+	r, _ := NewSampleReader(bytes.NewReader([]byte{1, 2, 3, 4, 5, 6}), "cs16le")
+	read := make([]complex128, 2)
+	if n, err := r.Read(read); n != 1 || err != io.ErrUnexpectedEOF {
+		t.Errorf("%d samples, %v of a partial sample\n", n, err)
+	}
+	if read[0] != complex(float64(0x0201)/32767.0, float64(0x0403)/32767.0) {
+		t.Errorf("sample %v\n", read[0])
+	}
+
+	if _, err := NewSampleReader(r.reader, "cs12"); err == nil {
+		t.Error("no error for unknown format")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestDvb2sDecoder(t *testing.T) {
+	stream := makeTsPackets(300, tsPacketSize)
+
+	for _, test := range []struct {
+		modcod       int
+		pilots       bool
+		oversampling int
+		rolloff      float64
+		gold         int
+	}{
+		{7, false, 2, 0.35, 0},
+		{14, true, 3, 0.20, 12345},
+	} {
+		name := fmt.Sprintf("%s pilots %t oversampling %d rolloff %.2f gold %d", modcodName[test.modcod], test.pilots, test.oversampling, test.rolloff, test.gold)
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEncoderModcod(test.modcod, "normal", test.pilots, test.oversampling, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := e.SetRolloff(test.rolloff); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.SetGoldCode(test.gold); err != nil {
+				t.Fatal(err)
+			}
+
+			var recording bytes.Buffer
+			w, _ := NewSampleWriter(&recording, "cs16le", 12.0)
+			scale := complex(float64(test.oversampling), 0.0)
+			err = e.Encode(context.Background(), bytes.NewReader(stream), func(samples []complex128) error {
+				for i := range samples {
+					samples[i] *= scale
+				}
+				return w.Write(samples)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Flush()
+
+			var output bytes.Buffer
+			d, err := NewDecoder(&output, test.oversampling)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := d.SetRolloff(test.rolloff); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.SetGoldCode(test.gold); err != nil {
+				t.Fatal(err)
+			}
+
+			r, _ := NewSampleReader(&recording, "cs16le")
+			samples := make([]complex128, 10007)
+			for {
+				n, err := r.Read(samples)
+				if err := d.Decode(samples[:n]); err != nil {
+					t.Fatal(err)
+				}
+				if err == io.EOF {
+					break
+				}
+			}
+			if err := d.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			s := d.Status()
+			if s.Modcod != test.modcod || s.ShortFrames || s.Pilots != test.pilots || s.Quality.Mer < 30.0 {
+				t.Errorf("%s short %t pilots %t MER %f dB\n", s.ModcodName(), s.ShortFrames, s.Pilots, s.Quality.Mer)
+			}
+			if s.FrameErrors != 0 || s.SyncLosses != 0 || s.CrcErrors != 0 || s.Frames < 2 {
+				t.Errorf("%+v\n", s)
+			}
+			if !bytes.HasPrefix(stream, output.Bytes()) || s.Packets*tsPacketSize != output.Len() || output.Len() < len(stream)/2 {
+				t.Errorf("%d of %d bytes\n", output.Len(), len(stream))
+			}
+		})
+	}
+
+	if _, err := NewDecoder(io.Discard, 1); err == nil {
+		t.Error("no error for oversampling 1")
+	}
+
+	t.Run("frame errors", func(t *testing.T) {
+		e := newDvb2s("normal", 2, false)
+		e.SetInputStream(bytes.NewReader(makeTsPackets(100, tsPacketSize)))
+
+		d, _ := NewDecoder(io.Discard, 2)
+		e.LoadInputStream()
+		e.bbHeader.setDataFieldToUserPacketDistance(e.bbHeader.getDataFieldLength())
+		e.bbHeader.update()
+		copy(e.bbFrame, e.bbHeader.bytes[:])
+		e.encodeFrame()
+		if err := d.decodeFrame(e.plSymbols); err != nil {
+			t.Fatal(err)
+		}
+		if s := d.Status(); s.FrameErrors != 1 || s.Packets != 0 {
+			t.Errorf("%+v\n", s)
+		}
+
+		d, _ = NewDecoder(failingWriter{}, 2)
+		e.LoadInputStream()
+		e.encodeFrame()
+		if err := d.decodeFrame(e.plSymbols); err != io.ErrClosedPipe {
+			t.Errorf("error of the writer: %v\n", err)
+		}
+	})
+
+	t.Run("SetLdpc", func(t *testing.T) {
+		d, _ := NewDecoder(io.Discard, 2)
+		d.r.modcod = 7
+		made, _ := d.r.decoder()
+		if err := d.SetLdpc(20, 1.0, 0.5); err != nil {
+			t.Fatal(err)
+		}
+		d.r.modcod = 14
+		later, _ := d.r.decoder()
+
+		for _, decoder := range []*receiverDecoder{made, later} {
+			l := decoder.ldpcDecoder
+			if l.maxIterations != 20 || l.scale != 1.0 || l.offset != 0.5 {
+				t.Errorf("iterations %d scale %f offset %f\n", l.maxIterations, l.scale, l.offset)
+			}
+		}
+
+		for _, ldpc := range [][3]float64{{0, 0.75, 0.0}, {50, 0.0, 0.0}, {50, 1.5, 0.0}, {50, 0.75, -1.0}} {
+			if d.SetLdpc(int(ldpc[0]), ldpc[1], ldpc[2]) == nil {
+				t.Errorf("no error for %v\n", ldpc)
+			}
+		}
 	})
 }
@@ -0,0 +1,270 @@
+package dvb2s
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+const (
+	tsPacketSize    int   = 188
+	tsRsPacketSize  int   = 204 // 188 bytes of packet followed by 16 bytes of RS parity
+	tsSyncByte      uint8 = 0x47
+	tsSyncLockCount int   = 3 // sync bytes in a row needed to lock on a stream
+)
+
+type tsReader struct {
+	reader       *bufio.Reader
+	buffer       [tsRsPacketSize]byte
+	packetSize   int
+	locked       bool
+	packets      int
+	syncLosses   int
+	skippedBytes int
+}
+
+func newTsReader(reader io.Reader) *tsReader {
+	var t tsReader
+
+	t.reader = bufio.NewReader(reader)
+
+	return &t
+}
+
+// readPacket copies the next 188 bytes packet into packet, RS parity bytes of
+// 204 bytes packets are dropped. A packet is accepted when the sync byte of the
+// following one is in place, otherwise the stream is resynchronized on 0x47
+// bytes. io.EOF is returned at the end of the stream.
+func (t *tsReader) readPacket(packet []byte) error {
+	if t.locked {
+		buffer, err := t.reader.Peek(t.packetSize + 1)
+		if len(buffer) == 0 {
+			return err
+		}
+		if buffer[0] != tsSyncByte || (len(buffer) > t.packetSize && buffer[t.packetSize] != tsSyncByte) {
+			t.locked = false
+			t.syncLosses++
+			t.reader.Discard(1)
+			t.skippedBytes++
+		}
+	}
+
+	if !t.locked {
+		if err := t.acquire(); err != nil {
+			return err
+		}
+	}
+
+	buffer := t.buffer[:t.packetSize]
+	if _, err := io.ReadFull(t.reader, buffer); err != nil {
+		return err
+	}
+	copy(packet, buffer[:tsPacketSize])
+	t.packets++
+
+	return nil
+}
+
+func (t *tsReader) acquire() error {
+	for {
+		head, err := t.reader.Peek(1)
+		if err != nil {
+			return err
+		}
+
+		if head[0] == tsSyncByte {
+			for _, size := range [...]int{tsPacketSize, tsRsPacketSize} {
+				if t.isSynchronized(size) {
+					t.packetSize = size
+					t.locked = true
+					return nil
+				}
+			}
+		}
+
+		t.reader.Discard(1)
+		t.skippedBytes++
+	}
+}
+
+// isSynchronized checks the sync bytes of tsSyncLockCount packets of the given
+// size, the stream ending with whole packets is accepted with fewer of them.
+func (t *tsReader) isSynchronized(size int) bool {
+	buffer, err := t.reader.Peek((tsSyncLockCount-1)*size + 1)
+	if err != nil && (len(buffer) < size || len(buffer)%size != 0) {
+		return false
+	}
+
+	for i := 0; i < len(buffer); i += size {
+		if buffer[i] != tsSyncByte {
+			return false
+		}
+	}
+
+	return true
+}
+
+const tsTransportErrorIndicator uint8 = 0x80
+const tsNullPid int = 0x1fff
+
+// tsDeframer rebuilds TS packets from the data fields of received BBFRAMEs.
+// The first byte of a user packet is CRC-8 of the previous one, so a packet
+// is written when the next one starts. Packets failing the check are marked
+// with transport_error_indicator. With null packet deletion every user packet
+// is followed by DNP, the number of null packets deleted before it.
+type tsDeframer struct {
+	writer      io.Writer
+	slot        [tsPacketSize + 1]byte
+	slotSize    int
+	pointer     int // bytes of the slot received, -1 out of sync
+	pending     [tsPacketSize]byte
+	hasPending  bool
+	pendingCrc  uint8
+	nullPacket  [tsPacketSize]byte
+	packets     int
+	crcErrors   int
+	nullPackets int
+	syncLosses  int
+}
+
+func newTsDeframer(writer io.Writer) *tsDeframer {
+	var t tsDeframer
+
+	t.writer = writer
+	t.pointer = -1
+
+	t.nullPacket[0] = tsSyncByte
+	t.nullPacket[1] = uint8(tsNullPid >> 8)
+	t.nullPacket[2] = uint8(tsNullPid & 0xff)
+	t.nullPacket[3] = 0x10
+	for i := 4; i < len(t.nullPacket); i++ {
+		t.nullPacket[i] = 0xff
+	}
+
+	return &t
+}
+
+// checkTsFrame tells why the BBFRAME of the header can not carry TS packets.
+func checkTsFrame(h *BbHeader) error {
+	if h.StreamType != TransportStream || h.UserPacketLength != tsPacketSize*8 {
+		return fmt.Errorf("not a transport stream: MATYPE-1 %02x, UPL %d", h.StreamType, h.UserPacketLength)
+	}
+	if h.SyncDistance != 0xffff && h.SyncDistance/8 >= h.DataFieldLength/8 {
+		return fmt.Errorf("SYNCD %d exceeds DFL %d", h.SyncDistance, h.DataFieldLength)
+	}
+	return nil
+}
+
+// push takes the data field of a descrambled BBFRAME with the parsed header.
+// The BBFRAME is checked by checkTsFrame first, other errors are of the writer.
+func (t *tsDeframer) push(h *BbHeader, bbFrame []byte) error {
+	if err := checkTsFrame(h); err != nil {
+		return err
+	}
+
+	slotSize := tsPacketSize
+	if h.NullPacketDeletion {
+		slotSize++
+	}
+	if slotSize != t.slotSize {
+		t.slotSize = slotSize
+		t.pointer = -1
+	}
+
+	data := bbFrame[bbHeaderSize : bbHeaderSize+h.DataFieldLength/8]
+
+	if h.SyncDistance != 0xffff {
+		start := h.SyncDistance / 8
+		expected := 0
+		if t.pointer > 0 {
+			expected = t.slotSize - t.pointer
+		}
+		if t.pointer < 0 || expected != start {
+			if t.pointer >= 0 {
+				t.syncLosses++
+			}
+			if err := t.resync(); err != nil {
+				return err
+			}
+			t.pointer = 0
+			data = data[start:]
+		}
+	}
+
+	if t.pointer < 0 {
+		return nil
+	}
+
+	for len(data) > 0 {
+		n := copy(t.slot[t.pointer:t.slotSize], data)
+		t.pointer += n
+		data = data[n:]
+		if t.pointer == t.slotSize {
+			if err := t.completeSlot(); err != nil {
+				return err
+			}
+			t.pointer = 0
+		}
+	}
+
+	return nil
+}
+
+func (t *tsDeframer) completeSlot() error {
+	if t.hasPending {
+		if t.slot[0] != t.pendingCrc {
+			t.pending[1] |= tsTransportErrorIndicator
+			t.crcErrors++
+		}
+		if err := t.write(t.pending[:]); err != nil {
+			return err
+		}
+	}
+
+	if t.slotSize > tsPacketSize {
+		for i := 0; i < int(t.slot[tsPacketSize]); i++ {
+			if err := t.write(t.nullPacket[:]); err != nil {
+				return err
+			}
+			t.nullPackets++
+		}
+	}
+
+	t.pending[0] = tsSyncByte
+	copy(t.pending[1:], t.slot[1:tsPacketSize])
+	t.pendingCrc = crc8Checksum(t.slot[1:tsPacketSize])
+	t.hasPending = true
+
+	return nil
+}
+
+// resync drops the partial packet, the pending packet lost its CRC-8 and goes
+// out marked.
+func (t *tsDeframer) resync() error {
+	if !t.hasPending {
+		return nil
+	}
+
+	t.hasPending = false
+	t.pending[1] |= tsTransportErrorIndicator
+	t.crcErrors++
+
+	return t.write(t.pending[:])
+}
+
+// flush writes the last packet, there is no CRC-8 to check it.
+func (t *tsDeframer) flush() error {
+	if !t.hasPending {
+		return nil
+	}
+
+	t.hasPending = false
+
+	return t.write(t.pending[:])
+}
+
+func (t *tsDeframer) write(packet []byte) error {
+	t.packets++
+	_, err := t.writer.Write(packet)
+	return err
+}
@@ -0,0 +1,3 @@
+module github.com/ivantaran/dvb2sgo
+
+go 1.16